@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fbs.com/social-collector/providers"
+	"fbs.com/social-collector/types"
+	"log/slog"
+	"time"
+)
+
+const recordAttemptQuery = `insert into social.lookup_attempts (user_id, provider, status, attempted_at) values ($1, $2, $3, $4)
+on conflict (user_id, provider) do update set status = excluded.status, attempted_at = excluded.attempted_at`
+
+// lookupStatus classifies the outcome of a provider request. Only failures
+// worth sending again, see providers.Retryable, and rejected credentials are
+// errors, which the worker retries without waiting for the cool-down. A 404
+// is the provider's answer for an unknown person; other permanent failures,
+// such as a 422 for a malformed address, are rejected and wait for the
+// cool-down like any answer.
+func lookupStatus(social types.Social, err error) string {
+	var queued *providers.QueuedError
+	var status *providers.StatusError
+	switch {
+	case errors.As(err, &queued):
+		return types.LookupQueued
+	case errors.As(err, &status) && status.Code == 404:
+		return types.LookupNotFound
+	case errors.As(err, &status) && (status.Code == 401 || status.Code == 403):
+		return types.LookupError
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return types.LookupError
+	case err != nil && providers.Retryable(err):
+		return types.LookupError
+	case err != nil:
+		return types.LookupRejected
+	case !social.HasProfiles():
+		return types.LookupNotFound
+	default:
+		return types.LookupFound
+	}
+}
+
+// recordAttempt stores the latest lookup of user by provider, so that the
+// worker skips it until the cool-down has passed.
+func recordAttempt(user types.User, provider string, status string) error {
+	_, err := dbMap.Exec(recordAttemptQuery, user.Id, provider, status, time.Now())
+	return err
+}
+
+// retryLater writes an unfinished lookup as failed, so that the next sweep
+// picks the user up again instead of waiting for the cool-down.
+func retryLater(user types.User, provider string) {
+	unfinished := errors.New("lookup left unfinished, retried by the next sweep")
+	if err := results.Write(result{User: user, Provider: provider, Status: types.LookupError, Err: unfinished, At: time.Now()}); err != nil {
+		slog.Error("write result", "sink", results.Name(), "user_id", user.Id, "error", err)
+	}
+	enrichQueue.Finished(user.Id, types.LookupError, types.Social{}, unfinished)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fbs.com/social-collector/providers"
+	"fbs.com/social-collector/types"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestAttempts(t *testing.T) {
+
+	Convey("Attempts", t, func() {
+
+		Convey("Lookup status", func() {
+			So(lookupStatus(types.Social{UserId: 1, TwitterUrl: "http://test.com"}, nil), ShouldEqual, types.LookupFound)
+			So(lookupStatus(types.Social{UserId: 1}, nil), ShouldEqual, types.LookupNotFound)
+			So(lookupStatus(types.Social{}, &providers.QueuedError{}), ShouldEqual, types.LookupQueued)
+			So(lookupStatus(types.Social{}, &providers.StatusError{Code: 500}), ShouldEqual, types.LookupError)
+			So(lookupStatus(types.Social{}, &providers.StatusError{Code: 429}), ShouldEqual, types.LookupError)
+			So(lookupStatus(types.Social{}, &providers.StatusError{Code: 403}), ShouldEqual, types.LookupError)
+			So(lookupStatus(types.Social{}, context.Canceled), ShouldEqual, types.LookupError)
+			So(lookupStatus(types.Social{}, &providers.StatusError{Code: 404}), ShouldEqual, types.LookupNotFound)
+			So(lookupStatus(types.Social{}, &providers.StatusError{Code: 400}), ShouldEqual, types.LookupRejected)
+			So(lookupStatus(types.Social{}, &providers.StatusError{Code: 422}), ShouldEqual, types.LookupRejected)
+			So(lookupStatus(types.Social{}, errors.New("Request:not parse")), ShouldEqual, types.LookupRejected)
+		})
+
+		Convey("Search records the attempt", func() {
+
+			cfg.Database.Driver = `testdb`
+			err := initDb()
+			So(err, ShouldBeNil)
+			defer dbMap.Db.Close()
+
+			var recorded []driver.Value
+			testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (result driver.Result, err error) {
+				if strings.Contains(query, "social.lookup_attempts") {
+					recorded = args
+				}
+				return testResult{1, 1}, nil
+			})
+			defer testdb.Reset()
+
+			backend := testBackend(200, `{"status":200}`)
+			defer backend.Close()
+
+			provider := providers.Fullcontact{Url: backend.URL, ApiKey: "1"}
+
+			err = search(context.Background(), types.User{Id: 7, Email: "test@test.com"}, provider)
+
+			So(err, ShouldBeNil)
+			So(recorded, ShouldHaveLength, 4)
+			So(recorded[0], ShouldEqual, 7)
+			So(recorded[1], ShouldEqual, "fullcontact")
+			So(recorded[2], ShouldEqual, types.LookupNotFound)
+		})
+
+		Convey("Search records unknown and refused addresses under the cool-down", func() {
+
+			cfg.Database.Driver = `testdb`
+			err := initDb()
+			So(err, ShouldBeNil)
+			defer dbMap.Db.Close()
+
+			var recorded []driver.Value
+			testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (result driver.Result, err error) {
+				if strings.Contains(query, "social.lookup_attempts") {
+					recorded = args
+				}
+				return testResult{1, 1}, nil
+			})
+			defer testdb.Reset()
+
+			notFound := testBackend(404, `{"status":404,"message":"Searched within last 24 hours. No results found for this Id."}`)
+			defer notFound.Close()
+
+			err = search(context.Background(), types.User{Id: 7, Email: "test@test.com"}, providers.Fullcontact{Url: notFound.URL, ApiKey: "1"})
+			So(err, ShouldBeNil)
+			So(recorded, ShouldHaveLength, 4)
+			So(recorded[2], ShouldEqual, types.LookupNotFound)
+
+			invalid := testBackend(422, `{"status":422,"message":"Invalid email address."}`)
+			defer invalid.Close()
+
+			err = search(context.Background(), types.User{Id: 8, Email: "test@"}, providers.Fullcontact{Url: invalid.URL, ApiKey: "1"})
+			So(err, ShouldNotBeNil)
+			So(recorded, ShouldHaveLength, 4)
+			So(recorded[0], ShouldEqual, 8)
+			So(recorded[2], ShouldEqual, types.LookupRejected)
+		})
+
+		Convey("Worker skips users within the cool-down", func() {
+
+			cfg.Database.Driver = `testdb`
+			err := initDb()
+			So(err, ShouldBeNil)
+			defer dbMap.Db.Close()
+
+			var selected string
+			var params []driver.Value
+			testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (result driver.Rows, err error) {
+				selected = query
+				params = args
+				return testdb.RowsFromCSVString([]string{"id", "email"}, ""), nil
+			})
+			defer testdb.Reset()
+
+			messages := make(chan types.User, 1)
+			maxId := 0
+			worker(context.Background(), testFeed(&messages), &maxId)
+
+			So(selected, ShouldContainSubstring, "social.lookup_attempts")
+			So(params, ShouldContain, "fullcontact")
+			So(params, ShouldContain, types.LookupError)
+		})
+
+	})
+}
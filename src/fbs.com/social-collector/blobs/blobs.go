@@ -0,0 +1,62 @@
+// Package blobs stores binary objects, such as mirrored photos, under
+// string keys. Stores are registered by type and selected with the
+// photos.store section of the config.
+package blobs
+
+import (
+	"errors"
+	"fbs.com/social-collector/types"
+	"sort"
+)
+
+const DefaultStore = "local"
+
+// Store keeps blobs under keys. Keys are relative, slash separated paths;
+// writing a key that exists replaces its blob.
+type Store interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Has(key string) (bool, error)
+}
+
+// Factory builds a Store from the collector configuration.
+type Factory func(cfg types.Config) (Store, error)
+
+var registry = map[string]Factory{}
+
+// Register makes a store available under name. It is meant to be called
+// from the init function of the file implementing the store.
+func Register(name string, factory Factory) {
+	if factory == nil {
+		panic("blobs: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("blobs: Register called twice for store " + name)
+	}
+	registry[name] = factory
+}
+
+// Names returns the sorted list of registered stores.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns the store selected by cfg.Photos.Store.Type, falling back to
+// DefaultStore when none is configured.
+func New(cfg types.Config) (Store, error) {
+	name := cfg.Photos.Store.Type
+	if name == "" {
+		name = DefaultStore
+	}
+
+	factory, ok := registry[name]
+	if !ok {
+		return nil, errors.New("New:unknown blob store " + name)
+	}
+	return factory(cfg)
+}
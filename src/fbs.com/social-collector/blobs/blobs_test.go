@@ -0,0 +1,66 @@
+package blobs
+
+import (
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type testStore struct {
+	Local
+}
+
+func TestRegistry(t *testing.T) {
+
+	Convey("Registry", t, func() {
+
+		Convey("Local is registered", func() {
+			So(Names(), ShouldContain, "local")
+		})
+
+		Convey("Empty store type falls back to local", func() {
+			cfg := types.Config{}
+			cfg.Photos.Store.Path = "/tmp/photos"
+
+			store, err := New(cfg)
+
+			So(err, ShouldBeNil)
+			So(store, ShouldResemble, Local{Dir: "/tmp/photos"})
+		})
+
+		Convey("Local needs a path", func() {
+			_, err := New(types.Config{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Unknown store returns error", func() {
+			cfg := types.Config{}
+			cfg.Photos.Store.Type = "unknown"
+
+			store, err := New(cfg)
+
+			So(store, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Registered store is selected by name", func() {
+			Register("test", func(cfg types.Config) (Store, error) {
+				return testStore{}, nil
+			})
+			defer delete(registry, "test")
+
+			cfg := types.Config{}
+			cfg.Photos.Store.Type = "test"
+			store, err := New(cfg)
+
+			So(err, ShouldBeNil)
+			So(store, ShouldHaveSameTypeAs, testStore{})
+		})
+
+		Convey("Registering twice panics", func() {
+			So(func() {
+				Register("local", NewLocal)
+			}, ShouldPanic)
+		})
+	})
+}
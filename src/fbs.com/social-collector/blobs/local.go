@@ -0,0 +1,98 @@
+package blobs
+
+import (
+	"errors"
+	"fbs.com/social-collector/types"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	Register("local", NewLocal)
+}
+
+// Local stores blobs as files under Dir. Files are spread over
+// subdirectories named after the first two characters of their key, which
+// keeps directories small for content hash keys.
+type Local struct {
+	Dir string
+}
+
+// NewLocal builds a Local store from the photos.store section of the
+// config.
+func NewLocal(cfg types.Config) (Store, error) {
+	if cfg.Photos.Store.Path == "" {
+		return nil, errors.New("NewLocal:photos.store.path is empty")
+	}
+	return Local{Dir: cfg.Photos.Store.Path}, nil
+}
+
+// path returns the file of key, refusing keys that would escape Dir.
+func (l Local) path(key string) (string, error) {
+	if key == "" || path.Clean(key) != key || path.IsAbs(key) || key == ".." || strings.HasPrefix(key, "../") || strings.Contains(key, `\`) {
+		return "", errors.New("Local:bad key " + key)
+	}
+	shard := key
+	if len(shard) > 2 {
+		shard = shard[:2]
+	}
+	return filepath.Join(l.Dir, shard, filepath.FromSlash(key)), nil
+}
+
+// Put writes data to a temporary file first, so that a key never holds a
+// partial blob.
+func (l Local) Put(key string, data []byte) (err error) {
+
+	file, err := l.path(key)
+	if err != nil {
+		return
+	}
+	dir := filepath.Dir(file)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(dir, ".put-*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if err = tmp.Close(); err != nil {
+		return
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func (l Local) Get(key string) ([]byte, error) {
+	file, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(file)
+}
+
+func (l Local) Has(key string) (bool, error) {
+	file, err := l.path(key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
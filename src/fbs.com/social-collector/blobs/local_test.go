@@ -0,0 +1,65 @@
+package blobs
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+
+	Convey("Local store", t, func() {
+
+		dir, err := ioutil.TempDir("", "blobs")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store := Local{Dir: dir}
+
+		Convey("Stores blobs in a directory per key prefix", func() {
+			So(store.Put("abcdef.jpg", []byte("photo")), ShouldBeNil)
+
+			data, err := ioutil.ReadFile(filepath.Join(dir, "ab", "abcdef.jpg"))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "photo")
+
+			data, err = store.Get("abcdef.jpg")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "photo")
+
+			entries, err := ioutil.ReadDir(filepath.Join(dir, "ab"))
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+		})
+
+		Convey("Reports whether a key exists", func() {
+			has, err := store.Has("abcdef.jpg")
+			So(err, ShouldBeNil)
+			So(has, ShouldBeFalse)
+
+			So(store.Put("abcdef.jpg", []byte("photo")), ShouldBeNil)
+			has, err = store.Has("abcdef.jpg")
+			So(err, ShouldBeNil)
+			So(has, ShouldBeTrue)
+		})
+
+		Convey("Replaces an existing blob", func() {
+			So(store.Put("abcdef.jpg", []byte("old")), ShouldBeNil)
+			So(store.Put("abcdef.jpg", []byte("new")), ShouldBeNil)
+
+			data, err := store.Get("abcdef.jpg")
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "new")
+		})
+
+		Convey("Refuses keys outside of its directory", func() {
+			for _, key := range []string{"", "../x", "/etc/passwd", "a/../../x", "./x", `a\b`} {
+				So(store.Put(key, []byte("x")), ShouldNotBeNil)
+				_, err := store.Has(key)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
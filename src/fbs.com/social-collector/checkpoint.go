@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+const (
+	loadCheckpointQuery = `select max_id from social.checkpoints where source = $1 and provider = $2`
+	saveCheckpointQuery = `insert into social.checkpoints (source, provider, max_id, updated_at) values ($1, $2, $3, now())
+on conflict (source, provider) do update set max_id = excluded.max_id, updated_at = excluded.updated_at`
+)
+
+// loadCheckpoint returns the id the worker of provider stopped at in source,
+// 0 when it never ran.
+func loadCheckpoint(source string, provider string) (int, error) {
+	maxId, err := dbMap.SelectInt(loadCheckpointQuery, source, provider)
+	return int(maxId), err
+}
+
+// saveCheckpoint records that the worker of provider is done with every
+// user of source up to maxId.
+func saveCheckpoint(source string, provider string, maxId int) error {
+	_, err := dbMap.Exec(saveCheckpointQuery, source, provider, maxId)
+	return err
+}
+
+// resumeCheckpoint returns the id to resume the sweep from, starting over
+// when the checkpoint cannot be read.
+func resumeCheckpoint(source string, provider string) int {
+	maxId, err := loadCheckpoint(source, provider)
+	if err != nil {
+		slog.Error("load checkpoint, starting from the first user", "source", source, "provider", provider, "error", err)
+		return 0
+	}
+	if maxId > 0 {
+		slog.Info("resuming from checkpoint", "source", source, "provider", provider, "max_id", maxId)
+	}
+	return maxId
+}
+
+// runCheckpoint implements "social-collector checkpoint [-provider P]
+// [-reset | -set N]": it prints the worker cursor of the configured source,
+// or moves it.
+func runCheckpoint(w io.Writer, args []string) (err error) {
+
+	flags := flag.NewFlagSet("checkpoint", flag.ContinueOnError)
+	flags.SetOutput(w)
+	provider := flags.String("provider", providerName(), "provider whose cursor to show or move")
+	reset := flags.Bool("reset", false, "start the next sweep from the first user")
+	set := flags.Int("set", -1, "start the next sweep after this user id")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if flags.NArg() > 0 || (*reset && *set >= 0) {
+		return errors.New("usage: social-collector checkpoint [-provider P] [-reset | -set N]")
+	}
+
+	source := source().Table
+
+	switch {
+	case *reset:
+		err = saveCheckpoint(source, *provider, 0)
+	case *set >= 0:
+		err = saveCheckpoint(source, *provider, *set)
+	}
+	if err != nil {
+		return
+	}
+
+	maxId, err := loadCheckpoint(source, *provider)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "%s\t%s\t%d\n", source, *provider, maxId)
+	return
+}
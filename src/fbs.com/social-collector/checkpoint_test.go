@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"fbs.com/social-collector/types"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+
+	Convey("Checkpoint", t, func() {
+
+		cfg.Database.Driver = `testdb`
+		So(initDb(), ShouldBeNil)
+		defer dbMap.Db.Close()
+		defer testdb.Reset()
+
+		stored := map[string]int{}
+		testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+			if strings.Contains(query, "social.checkpoints") {
+				stored[args[0].(string)+"/"+args[1].(string)] = int(args[2].(int64))
+			}
+			return testResult{1, 1}, nil
+		})
+		testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+			if strings.Contains(query, "social.checkpoints") {
+				maxId, ok := stored[args[0].(string)+"/"+args[1].(string)]
+				if !ok {
+					return testdb.RowsFromSlice([]string{"max_id"}, nil), nil
+				}
+				return testdb.RowsFromSlice([]string{"max_id"}, [][]driver.Value{{int64(maxId)}}), nil
+			}
+			return testdb.RowsFromCSVString([]string{"id", "email"}, "7,test@test.ru\n9,other@test.ru"), nil
+		})
+
+		Convey("Starts from the first user without a checkpoint", func() {
+			So(resumeCheckpoint("personal_area.user", "fullcontact"), ShouldEqual, 0)
+		})
+
+		Convey("Resumes from the saved checkpoint", func() {
+			So(saveCheckpoint("personal_area.user", "fullcontact", 42), ShouldBeNil)
+			So(resumeCheckpoint("personal_area.user", "fullcontact"), ShouldEqual, 42)
+			So(resumeCheckpoint("personal_area.user", "other"), ShouldEqual, 0)
+		})
+
+		Convey("Worker saves the cursor up to the first user still looked up", func() {
+			messages := make(chan types.User, 2)
+			f := testFeed(&messages)
+			maxId := 0
+
+			worker(context.Background(), f, &maxId)
+
+			So(maxId, ShouldEqual, 9)
+			So(stored["personal_area.user/fullcontact"], ShouldEqual, 6)
+
+			f.queue.Done(<-messages)
+			saveProgress(context.Background(), f, maxId)
+			So(stored["personal_area.user/fullcontact"], ShouldEqual, 8)
+
+			f.queue.Done(<-messages)
+			saveProgress(context.Background(), f, maxId)
+			So(stored["personal_area.user/fullcontact"], ShouldEqual, 9)
+		})
+
+		Convey("Worker keeps the users of a batch cancelled midway for the next run", func() {
+			messages := make(chan types.User, 2)
+			f := testFeed(&messages)
+			maxId := 0
+			ctx, cancel := context.WithCancel(context.Background())
+
+			worker(ctx, f, &maxId)
+
+			// The first user is looked up, then the collector shuts down
+			// and the listener drops the second one.
+			f.queue.Done(<-messages)
+			cancel()
+			close(messages)
+			listenLoop(ctx, &messages, &countingProvider{}, f.queue, nil)
+			saveProgress(ctx, f, maxId)
+
+			So(resumeCheckpoint("personal_area.user", "fullcontact"), ShouldEqual, 6)
+		})
+
+		Convey("Worker does not save an interrupted batch", func() {
+			messages := make(chan types.User, 1)
+			maxId := 0
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			worker(ctx, testFeed(&messages), &maxId)
+
+			_, saved := stored["personal_area.user/fullcontact"]
+			So(saved, ShouldBeFalse)
+		})
+
+		Convey("Worker leaves the cursor alone on a dry run", func() {
+			defer func() { dryRun = false }()
+			dryRun = true
+			messages := make(chan types.User, 2)
+			maxId := 0
+
+			worker(context.Background(), testFeed(&messages), &maxId)
+
+			So(maxId, ShouldEqual, 9)
+			So(stored, ShouldBeEmpty)
+		})
+
+		Convey("Subcommand shows, sets and resets the cursor", func() {
+			var out bytes.Buffer
+
+			So(runCheckpoint(&out, []string{"-set", "15"}), ShouldBeNil)
+			So(out.String(), ShouldEqual, "personal_area.user\tfullcontact\t15\n")
+
+			out.Reset()
+			So(runCheckpoint(&out, []string{"-provider", "other"}), ShouldBeNil)
+			So(out.String(), ShouldEqual, "personal_area.user\tother\t0\n")
+
+			out.Reset()
+			So(runCheckpoint(&out, []string{"-reset"}), ShouldBeNil)
+			So(out.String(), ShouldEqual, "personal_area.user\tfullcontact\t0\n")
+
+			So(runCheckpoint(&out, []string{"-reset", "-set", "3"}), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,66 @@
+// Package emails canonicalizes email addresses, so that the variants of a
+// mailbox (case, Gmail dots, +tag suffixes, domain aliases) map to one
+// address.
+package emails
+
+import (
+	"fbs.com/social-collector/types"
+	"strings"
+)
+
+// DefaultRules are the rules of the mailbox providers known to ignore
+// dots and +tags. Configured rules take precedence over them, domain by
+// domain.
+var DefaultRules = map[string]types.EmailRule{
+	"gmail.com":      {Dots: true, Plus: true},
+	"googlemail.com": {Dots: true, Plus: true, Alias: "gmail.com"},
+}
+
+// Normalizer canonicalizes addresses by the rule of their domain.
+type Normalizer struct {
+	rules map[string]types.EmailRule
+}
+
+// New returns a Normalizer applying rules on top of DefaultRules. Domains
+// are matched case-insensitively.
+func New(rules map[string]types.EmailRule) Normalizer {
+	n := Normalizer{rules: map[string]types.EmailRule{}}
+	for domain, rule := range DefaultRules {
+		n.rules[domain] = rule
+	}
+	for domain, rule := range rules {
+		rule.Alias = strings.ToLower(rule.Alias)
+		n.rules[strings.ToLower(domain)] = rule
+	}
+	return n
+}
+
+// Canonical returns the canonical form of email: trimmed, lower-cased and
+// normalized by the rule of its domain. Strings that are not addresses are
+// only trimmed and lower-cased.
+func (n Normalizer) Canonical(email string) string {
+
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+
+	rule, ok := n.rules[domain]
+	if !ok {
+		rule = n.rules["*"]
+	}
+
+	if i := strings.Index(local, "+"); rule.Plus && i > 0 {
+		local = local[:i]
+	}
+	if stripped := strings.ReplaceAll(local, ".", ""); rule.Dots && stripped != "" {
+		local = stripped
+	}
+	if rule.Alias != "" {
+		domain = rule.Alias
+	}
+	return local + "@" + domain
+}
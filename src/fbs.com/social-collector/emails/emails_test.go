@@ -0,0 +1,46 @@
+package emails
+
+import (
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestCanonical(t *testing.T) {
+
+	Convey("Canonical", t, func() {
+
+		Convey("Lower-cases and trims every address", func() {
+			n := New(nil)
+			So(n.Canonical("  John.Doe+News@Example.COM "), ShouldEqual, "john.doe+news@example.com")
+			So(n.Canonical("Not An Address"), ShouldEqual, "not an address")
+			So(n.Canonical("@example.com"), ShouldEqual, "@example.com")
+		})
+
+		Convey("Applies the built in Gmail rules", func() {
+			n := New(nil)
+			So(n.Canonical("John.Doe+news@gmail.com"), ShouldEqual, "johndoe@gmail.com")
+			So(n.Canonical("j.o.h.n.doe@GoogleMail.com"), ShouldEqual, "johndoe@gmail.com")
+			So(n.Canonical("+tag@gmail.com"), ShouldEqual, "+tag@gmail.com")
+			So(n.Canonical("...@gmail.com"), ShouldEqual, "...@gmail.com")
+		})
+
+		Convey("Configured rules replace the built in ones, by domain", func() {
+			n := New(map[string]types.EmailRule{
+				"Gmail.com":   {},
+				"example.com": {Plus: true},
+				"example.org": {Dots: true, Alias: "Example.com"},
+			})
+			So(n.Canonical("john.doe+news@gmail.com"), ShouldEqual, "john.doe+news@gmail.com")
+			So(n.Canonical("john.doe+news@googlemail.com"), ShouldEqual, "johndoe@gmail.com")
+			So(n.Canonical("john.doe+news@example.com"), ShouldEqual, "john.doe@example.com")
+			So(n.Canonical("john.doe+news@example.org"), ShouldEqual, "johndoe+news@example.com")
+		})
+
+		Convey("The wildcard rule applies to the other domains", func() {
+			n := New(map[string]types.EmailRule{"*": {Plus: true}})
+			So(n.Canonical("john+news@example.net"), ShouldEqual, "john@example.net")
+			So(n.Canonical("john.doe+news@gmail.com"), ShouldEqual, "johndoe@gmail.com")
+		})
+	})
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fbs.com/social-collector/types"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// enrichPending is the status of a job waiting for its first lookup.
+	enrichPending = "pending"
+	maxEnrichWait = time.Minute
+	enrichJobTTL  = time.Hour
+)
+
+var errPipelineStopped = errors.New("Submit:pipeline is not running")
+
+// enrichJob is an enrichment requested through the API.
+type enrichJob struct {
+	UserId      int           `json:"user_id"`
+	Status      string        `json:"status"`
+	Error       string        `json:"error,omitempty"`
+	Social      *types.Social `json:"social,omitempty"`
+	SubmittedAt *time.Time    `json:"submitted_at,omitempty"`
+	FinishedAt  *time.Time    `json:"finished_at,omitempty"`
+	done        chan struct{}
+}
+
+// enrichments hands the users submitted through the API to the pipeline and
+// tracks their lookups.
+type enrichments struct {
+	// mu guards ctx and messages; it is held for reading while sending, so
+	// that Detach waits for the sends in progress.
+	mu       sync.RWMutex
+	ctx      context.Context
+	messages *chan types.User
+
+	jobsMu sync.Mutex
+	jobs   map[int]*enrichJob
+}
+
+var enrichQueue = newEnrichments()
+
+func newEnrichments() *enrichments {
+	return &enrichments{jobs: map[int]*enrichJob{}}
+}
+
+// Attach makes Submit send to messages until ctx is done.
+func (e *enrichments) Attach(ctx context.Context, messages *chan types.User) {
+	e.mu.Lock()
+	e.ctx, e.messages = ctx, messages
+	e.mu.Unlock()
+}
+
+// Detach stops sending to messages, which may be closed once it returns.
+func (e *enrichments) Detach() {
+	e.mu.Lock()
+	e.ctx, e.messages = nil, nil
+	e.mu.Unlock()
+}
+
+// Submit enqueues a lookup of user, unless one is already running, and
+// returns its job together with a channel closed once it finishes.
+func (e *enrichments) Submit(ctx context.Context, user types.User) (job enrichJob, done <-chan struct{}, err error) {
+
+	now := time.Now()
+
+	e.jobsMu.Lock()
+	for id, j := range e.jobs {
+		if j.FinishedAt != nil && now.Sub(*j.FinishedAt) > enrichJobTTL {
+			delete(e.jobs, id)
+		}
+	}
+	if j, ok := e.jobs[user.Id]; ok && j.FinishedAt == nil {
+		e.jobsMu.Unlock()
+		return *j, j.done, nil
+	}
+	j := &enrichJob{UserId: user.Id, Status: enrichPending, SubmittedAt: &now, done: make(chan struct{})}
+	e.jobs[user.Id] = j
+	e.jobsMu.Unlock()
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.messages == nil {
+		err = errPipelineStopped
+	} else {
+		select {
+		case *e.messages <- user:
+		case <-e.ctx.Done():
+			err = errPipelineStopped
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	e.jobsMu.Lock()
+	defer e.jobsMu.Unlock()
+	if err != nil {
+		delete(e.jobs, user.Id)
+		return
+	}
+	return *j, j.done, nil
+}
+
+// Finished records the outcome of a lookup of the user. A queued lookup
+// leaves the job running.
+func (e *enrichments) Finished(userId int, status string, social types.Social, err error) {
+
+	e.jobsMu.Lock()
+	defer e.jobsMu.Unlock()
+
+	j, ok := e.jobs[userId]
+	if !ok || j.FinishedAt != nil {
+		return
+	}
+
+	j.Status = status
+	if status == types.LookupQueued {
+		return
+	}
+
+	now := time.Now()
+	j.FinishedAt = &now
+	if err != nil {
+		j.Error = err.Error()
+	}
+	if status == types.LookupFound {
+		j.Social = &social
+	}
+	close(j.done)
+}
+
+// Get returns the job of the user, if one was submitted recently.
+func (e *enrichments) Get(userId int) (job enrichJob, ok bool) {
+	e.jobsMu.Lock()
+	defer e.jobsMu.Unlock()
+	j, ok := e.jobs[userId]
+	if ok {
+		job = *j
+	}
+	return
+}
+
+// enrichRequest is the body of POST /v1/enrich. Email is optional and only
+// checked against the source.
+type enrichRequest struct {
+	UserId int    `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+// postEnrich serves POST /v1/enrich: it enqueues the user whose id is given
+// in the body and, with ?wait=<duration>, waits up to that long for the
+// result. It answers 200 with a finished job and 202 with a running one.
+// The email is read from the source, so that a caller cannot store the
+// profiles of one address under another user: an email given in the body
+// that differs from it is answered 409, and unknown fields 400.
+func postEnrich(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	var request enrichRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "body must be {\"user_id\": N[, \"email\": E]}: "+err.Error())
+		return
+	}
+	if request.UserId < 1 {
+		writeError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	var wait time.Duration
+	if value := r.URL.Query().Get("wait"); value != "" {
+		var err error
+		if wait, err = time.ParseDuration(value); err != nil || wait < 0 {
+			writeError(w, http.StatusBadRequest, "wait must be a duration such as 10s")
+			return
+		}
+		if wait > maxEnrichWait {
+			wait = maxEnrichWait
+		}
+	}
+
+	src, err := newSource()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	user, ok, err := src.Find(request.UserId)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "no user "+strconv.Itoa(request.UserId)+" in the source")
+		return
+	}
+	if request.Email != "" && !strings.EqualFold(strings.TrimSpace(request.Email), user.Email) {
+		writeError(w, http.StatusConflict, "email does not match user "+strconv.Itoa(request.UserId)+" in the source")
+		return
+	}
+
+	job, done, err := enrichQueue.Submit(r.Context(), user)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		job, _ = enrichQueue.Get(request.UserId)
+	}
+
+	w.Header().Set("Location", "/v1/enrich/"+strconv.Itoa(request.UserId))
+	if job.FinishedAt != nil {
+		writeJSON(w, http.StatusOK, job)
+	} else {
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// getEnrich serves GET /v1/enrich/{id}: the job of the user when it was
+// submitted recently, otherwise its last recorded lookup.
+func getEnrich(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/v1/enrich/"))
+	if err != nil || id < 1 {
+		writeError(w, http.StatusBadRequest, "id must be a user id")
+		return
+	}
+
+	if job, ok := enrichQueue.Get(id); ok {
+		writeJSON(w, http.StatusOK, job)
+		return
+	}
+
+	job, ok, err := storedEnrichment(id)
+	switch {
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	case !ok:
+		writeError(w, http.StatusNotFound, "no lookup of user "+strconv.Itoa(id))
+	default:
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+type storedAttempt struct {
+	Status      string    `db:"status"`
+	AttemptedAt time.Time `db:"attempted_at"`
+}
+
+// storedEnrichment builds a job from the last lookup of the user recorded
+// by the configured provider.
+func storedEnrichment(id int) (job enrichJob, ok bool, err error) {
+
+	if dbMap == nil {
+		err = errors.New("storedEnrichment:database not connected")
+		return
+	}
+
+	var attempts []storedAttempt
+	_, err = dbMap.Select(&attempts, `select status, attempted_at from social.lookup_attempts where user_id = $1 and provider = $2`, id, providerName())
+	if err != nil || len(attempts) == 0 {
+		return
+	}
+
+	job = enrichJob{UserId: id, Status: attempts[0].Status, FinishedAt: &attempts[0].AttemptedAt}
+
+	var socials []types.Social
+	_, err = dbMap.Select(&socials, `select user_id, facebook_url, twitter_url, photo_url, photo_key, updated_at from social.users where user_id = $1`, id)
+	if err != nil {
+		return
+	}
+	if len(socials) > 0 {
+		job.Social = &socials[0]
+		_, err = dbMap.Select(&job.Social.Profiles, `select user_id, network, username, profile_id, url, bio, followers, following from social.profiles where user_id = $1 order by network, url`, id)
+		if err != nil {
+			return
+		}
+	}
+	return job, true, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fbs.com/social-collector/types"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnrich(t *testing.T) {
+
+	Convey("Enrich API", t, func() {
+
+		defer func(saved *enrichments) { enrichQueue = saved }(enrichQueue)
+		enrichQueue = newEnrichments()
+
+		defer func(saved types.Config) { cfg = saved }(cfg)
+		dir, err := ioutil.TempDir("", "enrich")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "users.jsonl")
+		So(ioutil.WriteFile(path, []byte("{\"id\": 3, \"email\": \"new@test.ru\"}\n{\"id\": 4, \"email\": \"four@test.ru\"}\n{\"id\": 5, \"email\": \"five@test.ru\"}\n{\"id\": 6, \"email\": \"six@test.ru\"}\n"), 0600), ShouldBeNil)
+		cfg.Source = types.Source{Type: "jsonl", Path: path}
+		cfg.Api.Token = ""
+
+		serve := func(method string, target string, body string) (*httptest.ResponseRecorder, enrichJob) {
+			w := httptest.NewRecorder()
+			apiMux().ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+			var job enrichJob
+			json.Unmarshal(w.Body.Bytes(), &job)
+			return w, job
+		}
+
+		Convey("Rejects requests while the pipeline is stopped", func() {
+			w, _ := serve("POST", "/v1/enrich", `{"user_id": 3}`)
+			So(w.Code, ShouldEqual, 503)
+
+			_, ok := enrichQueue.Get(3)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Rejects malformed requests", func() {
+			w, _ := serve("POST", "/v1/enrich", `{"email": "new@test.ru"}`)
+			So(w.Code, ShouldEqual, 400)
+			w, _ = serve("POST", "/v1/enrich?wait=soon", `{"user_id": 3}`)
+			So(w.Code, ShouldEqual, 400)
+			w, _ = serve("POST", "/v1/enrich", `{"user_id": 3, "mail": "new@test.ru"}`)
+			So(w.Code, ShouldEqual, 400)
+			w, _ = serve("GET", "/v1/enrich/abc", "")
+			So(w.Code, ShouldEqual, 400)
+			w, _ = serve("GET", "/v1/enrich", "")
+			So(w.Code, ShouldEqual, 405)
+		})
+
+		Convey("Is not served with the metrics", func() {
+			w := httptest.NewRecorder()
+			httpMux().ServeHTTP(w, httptest.NewRequest("POST", "/v1/enrich", strings.NewReader(`{"user_id": 3}`)))
+			So(w.Code, ShouldEqual, 404)
+		})
+
+		Convey("Requires the token when one is set", func() {
+			cfg.Api.Token = "secret"
+
+			w, _ := serve("GET", "/v1/enrich/3", "")
+			So(w.Code, ShouldEqual, 401)
+
+			w = httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/v1/enrich/3", nil)
+			r.Header.Set("Authorization", "Bearer wrong")
+			apiMux().ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, 401)
+
+			w = httptest.NewRecorder()
+			r = httptest.NewRequest("POST", "/v1/enrich", strings.NewReader(`{"user_id": 3}`))
+			r.Header.Set("Authorization", "Bearer secret")
+			apiMux().ServeHTTP(w, r)
+			So(w.Code, ShouldEqual, 503)
+		})
+
+		Convey("With a running pipeline", func() {
+			messages := make(chan types.User, 2)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			enrichQueue.Attach(ctx, &messages)
+
+			Convey("Enqueues the user and reports the job", func() {
+				w, job := serve("POST", "/v1/enrich", `{"user_id": 3}`)
+
+				So(w.Code, ShouldEqual, 202)
+				So(w.Header().Get("Location"), ShouldEqual, "/v1/enrich/3")
+				So(job.Status, ShouldEqual, enrichPending)
+				So(<-messages, ShouldResemble, types.User{Id: 3, Email: "new@test.ru"})
+
+				Convey("Does not enqueue a running job twice", func() {
+					w, _ := serve("POST", "/v1/enrich", `{"user_id": 3}`)
+					So(w.Code, ShouldEqual, 202)
+					So(messages, ShouldBeEmpty)
+				})
+
+				Convey("Reports the result once the lookup finished", func() {
+					enrichQueue.Finished(3, types.LookupQueued, types.Social{}, nil)
+					_, job := serve("GET", "/v1/enrich/3", "")
+					So(job.Status, ShouldEqual, types.LookupQueued)
+					So(job.FinishedAt, ShouldBeNil)
+
+					enrichQueue.Finished(3, types.LookupFound, types.Social{UserId: 3, TwitterUrl: "http://twitter.com/new"}, nil)
+					w, job = serve("GET", "/v1/enrich/3", "")
+					So(w.Code, ShouldEqual, 200)
+					So(job.Status, ShouldEqual, types.LookupFound)
+					So(job.FinishedAt, ShouldNotBeNil)
+					So(job.Social.TwitterUrl, ShouldEqual, "http://twitter.com/new")
+				})
+			})
+
+			Convey("Waits for the result when asked to", func() {
+				go func() {
+					user := <-messages
+					enrichQueue.Finished(user.Id, types.LookupNotFound, types.Social{}, nil)
+				}()
+
+				w, job := serve("POST", "/v1/enrich?wait=5s", `{"user_id": 4}`)
+
+				So(w.Code, ShouldEqual, 200)
+				So(job.Status, ShouldEqual, types.LookupNotFound)
+			})
+
+			Convey("Gives up waiting after the wait duration", func() {
+				started := time.Now()
+				w, _ := serve("POST", "/v1/enrich?wait=20ms", `{"user_id": 5}`)
+
+				So(w.Code, ShouldEqual, 202)
+				So(time.Since(started), ShouldBeGreaterThanOrEqualTo, 20*time.Millisecond)
+			})
+
+			Convey("Looks the email up in the source", func() {
+				w, _ := serve("POST", "/v1/enrich", `{"user_id": 4, "email": "someone@else.ru"}`)
+				So(w.Code, ShouldEqual, 409)
+				So(messages, ShouldBeEmpty)
+
+				w, _ = serve("POST", "/v1/enrich", `{"user_id": 4, "email": "Four@Test.ru"}`)
+				So(w.Code, ShouldEqual, 202)
+				So(<-messages, ShouldResemble, types.User{Id: 4, Email: "four@test.ru"})
+
+				w, _ = serve("POST", "/v1/enrich", `{"user_id": 9}`)
+				So(w.Code, ShouldEqual, 404)
+				So(messages, ShouldBeEmpty)
+			})
+
+			Convey("Refuses new jobs once detached", func() {
+				enrichQueue.Detach()
+				w, _ := serve("POST", "/v1/enrich", `{"user_id": 6}`)
+				So(w.Code, ShouldEqual, 503)
+			})
+		})
+
+		Convey("Falls back to the recorded lookup", func() {
+			cfg.Database.Driver = `testdb`
+			So(initDb(), ShouldBeNil)
+			defer dbMap.Db.Close()
+			defer testdb.Reset()
+
+			attempted := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+				if args[0].(int64) != 7 {
+					return testdb.RowsFromSlice([]string{"status", "attempted_at"}, nil), nil
+				}
+				if strings.Contains(query, "social.lookup_attempts") {
+					return testdb.RowsFromSlice([]string{"status", "attempted_at"}, [][]driver.Value{{"found", attempted}}), nil
+				}
+				if strings.Contains(query, "social.profiles") {
+					return testdb.RowsFromSlice([]string{"user_id", "network", "username", "profile_id", "url", "bio", "followers", "following"},
+						[][]driver.Value{{int64(7), "github", "old", "", "https://github.com/old", "", int64(4), int64(0)}}), nil
+				}
+				return testdb.RowsFromSlice([]string{"user_id", "facebook_url", "twitter_url", "photo_url", "updated_at"},
+					[][]driver.Value{{int64(7), "http://facebook.com/old", "", "", attempted}}), nil
+			})
+
+			w, job := serve("GET", "/v1/enrich/7", "")
+			So(w.Code, ShouldEqual, 200)
+			So(job.Status, ShouldEqual, types.LookupFound)
+			So(job.FinishedAt.Equal(attempted), ShouldBeTrue)
+			So(job.Social.FacebookUrl, ShouldEqual, "http://facebook.com/old")
+			So(job.Social.Profiles, ShouldResemble, []types.Profile{{Network: "github", Username: "old", Url: "https://github.com/old", Followers: 4}})
+
+			w, _ = serve("GET", "/v1/enrich/8", "")
+			So(w.Code, ShouldEqual, 404)
+		})
+	})
+}
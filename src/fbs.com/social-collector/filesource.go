@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fbs.com/social-collector/types"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fileSource reads users from a jsonl or csv file, or from stdin.
+type fileSource struct {
+	types.Source
+}
+
+func (s fileSource) Name() string {
+	return s.Type + ":" + s.Path
+}
+
+func (s fileSource) Check() error {
+	if s.Path == "-" {
+		return nil
+	}
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (s fileSource) Run(ctx context.Context, f feed) (err error) {
+
+	r, err := s.open()
+	if err != nil {
+		return
+	}
+	defer closeInput(r)
+
+	return s.read(r, func(user types.User) bool {
+		return f.Send(ctx, user)
+	})
+}
+
+// Find reads the file up to the user with the given id. Stdin can only be
+// read once, by Run, and is not searched.
+func (s fileSource) Find(id int) (user types.User, ok bool, err error) {
+
+	if s.Path == "-" {
+		return user, false, errors.New("fileSource:users cannot be found by id on stdin")
+	}
+	r, err := s.open()
+	if err != nil {
+		return
+	}
+	defer closeInput(r)
+
+	err = s.read(r, func(u types.User) bool {
+		user, ok = u, u.Id == id
+		return !ok
+	})
+	if !ok {
+		user = types.User{}
+	}
+	return
+}
+
+// open returns the file of the source, or stdin.
+func (s fileSource) open() (io.Reader, error) {
+	if s.Path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(s.Path)
+}
+
+func (s fileSource) read(r io.Reader, send func(types.User) bool) error {
+	if s.Type == "csv" {
+		return readCSV(r, s.Id, s.Email, send)
+	}
+	return readJSONL(r, s.Id, s.Email, send)
+}
+
+// closeInput closes r when it is a file the source opened.
+func closeInput(r io.Reader) {
+	if file, ok := r.(*os.File); ok && file != os.Stdin {
+		file.Close()
+	}
+}
+
+// readJSONL passes the user of every line of r, a JSON object with the id
+// and email keys, to send until it returns false. Malformed lines are
+// logged and skipped.
+func readJSONL(r io.Reader, idKey string, emailKey string, send func(types.User) bool) error {
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &fields); err != nil {
+			slog.Warn("skipping malformed line", "line", line, "error", err)
+			continue
+		}
+
+		email, _ := fields[emailKey].(string)
+		var id string
+		switch value := fields[idKey].(type) {
+		case string:
+			id = value
+		case float64:
+			id = strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		user, err := parseUser(id, email)
+		if err != nil {
+			slog.Warn("skipping line", "line", line, "error", err)
+			continue
+		}
+
+		if !send(user) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// readCSV passes the user of every row of r to send until it returns
+// false. The header row names the columns, which must include email.
+// Malformed rows are logged and skipped.
+func readCSV(r io.Reader, idColumn string, emailColumn string, send func(types.User) bool) error {
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	idIndex, emailIndex := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case strings.ToLower(idColumn):
+			idIndex = i
+		case strings.ToLower(emailColumn):
+			emailIndex = i
+		}
+	}
+	if emailIndex < 0 {
+		return errors.New("readCSV:no " + emailColumn + " column in the header")
+	}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			slog.Warn("skipping malformed row", "line", line, "error", err)
+			continue
+		}
+
+		var id, email string
+		if emailIndex < len(record) {
+			email = record[emailIndex]
+		}
+		if idIndex >= 0 && idIndex < len(record) {
+			id = record[idIndex]
+		}
+		user, err := parseUser(id, email)
+		if err != nil {
+			slog.Warn("skipping row", "line", line, "error", err)
+			continue
+		}
+
+		if !send(user) {
+			return nil
+		}
+	}
+}
+
+// parseUser builds a user from the raw fields of a file, the id being
+// optional.
+func parseUser(id string, email string) (user types.User, err error) {
+	user.Email = strings.TrimSpace(email)
+	if !strings.Contains(user.Email, "@") {
+		return user, errors.New("parseUser:no email")
+	}
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return
+	}
+	if user.Id, err = strconv.Atoi(id); err != nil || user.Id < 0 {
+		return user, errors.New("parseUser:bad id " + id)
+	}
+	return
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fbs.com/social-collector/types"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileSource(t *testing.T) {
+
+	Convey("File source", t, func() {
+
+		var users []types.User
+		collect := func(user types.User) bool {
+			users = append(users, user)
+			return true
+		}
+
+		Convey("Reads jsonl, with or without ids, skipping malformed lines", func() {
+			input := `{"id": 3, "email": "a@test.ru"}
+
+{"email": "b@test.ru"}
+not json
+{"id": "4", "email": "c@test.ru"}
+{"id": 5}
+{"id": "x", "email": "d@test.ru"}
+`
+			So(readJSONL(strings.NewReader(input), "id", "email", collect), ShouldBeNil)
+			So(users, ShouldResemble, []types.User{{Id: 3, Email: "a@test.ru"}, {Email: "b@test.ru"}, {Id: 4, Email: "c@test.ru"}})
+		})
+
+		Convey("Reads csv by the header names", func() {
+			input := "Mail,name,contact_id\na@test.ru,A,3\nb@test.ru,B,\nnot an email,C,5\n"
+
+			So(readCSV(strings.NewReader(input), "contact_id", "mail", collect), ShouldBeNil)
+			So(users, ShouldResemble, []types.User{{Id: 3, Email: "a@test.ru"}, {Email: "b@test.ru"}})
+		})
+
+		Convey("Needs an email column in csv", func() {
+			err := readCSV(strings.NewReader("id,name\n1,A\n"), "id", "email", collect)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "readCSV:no email column in the header")
+		})
+
+		Convey("Stops reading when send refuses", func() {
+			sent := 0
+			err := readJSONL(strings.NewReader("{\"email\": \"a@test.ru\"}\n{\"email\": \"b@test.ru\"}\n"), "id", "email", func(types.User) bool {
+				sent++
+				return false
+			})
+			So(err, ShouldBeNil)
+			So(sent, ShouldEqual, 1)
+		})
+
+		Convey("Check fails on a missing file", func() {
+			So(fileSource{types.Source{Type: "csv", Path: "/nonexistent.csv"}}.Check(), ShouldNotBeNil)
+			So(fileSource{types.Source{Type: "csv", Path: "-"}}.Check(), ShouldBeNil)
+		})
+
+		Convey("Finds users by id", func() {
+			dir, err := ioutil.TempDir("", "source")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "users.csv")
+			So(ioutil.WriteFile(path, []byte("id,email\n3,a@test.ru\n4,b@test.ru\n"), 0600), ShouldBeNil)
+
+			s := fileSource{types.Source{Type: "csv", Path: path, Id: "id", Email: "email"}}
+			user, ok, err := s.Find(4)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(user, ShouldResemble, types.User{Id: 4, Email: "b@test.ru"})
+
+			user, ok, err = s.Find(5)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+			So(user, ShouldResemble, types.User{})
+
+			_, _, err = fileSource{types.Source{Type: "csv", Path: "-"}}.Find(4)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Pipeline stops once every user of the file is looked up", func() {
+			defer func(saved types.Config) { cfg = saved }(cfg)
+
+			cfg.Database.Driver = `testdb`
+			So(initDb(), ShouldBeNil)
+			defer dbMap.Db.Close()
+			defer testdb.Reset()
+
+			var saved int32
+			testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+				if strings.Contains(query, "social.users") {
+					atomic.AddInt32(&saved, 1)
+				}
+				return testResult{1, 1}, nil
+			})
+
+			// The first request of every user is queued, the next succeeds.
+			var requests int32
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1)%2 == 1 {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(202)
+					return
+				}
+				w.Write([]byte(`{"status":200, "socialProfiles":[{"type":"twitter", "url":"http://twitter.com/test"}]}`))
+			}))
+			defer backend.Close()
+
+			dir, err := ioutil.TempDir("", "source")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "users.jsonl")
+			So(ioutil.WriteFile(path, []byte("{\"id\": 1, \"email\": \"a@test.ru\"}\n{\"email\": \"b@test.ru\"}\n"), 0600), ShouldBeNil)
+
+			cfg.Provider = ""
+			cfg.Fullcontact.Url = backend.URL
+			cfg.Concurrency = 1
+			cfg.Requeue.Attempts = 2
+			cfg.Requeue.Delay = time.Millisecond
+			cfg.Source = types.Source{Type: "jsonl", Path: path}
+
+			stopped := make(chan error)
+			go func() {
+				stopped <- start(context.Background())
+			}()
+
+			select {
+			case err := <-stopped:
+				So(err, ShouldBeNil)
+			case <-time.After(5 * time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+			So(atomic.LoadInt32(&requests), ShouldEqual, 4)
+			So(atomic.LoadInt32(&saved), ShouldEqual, 1)
+		})
+	})
+}
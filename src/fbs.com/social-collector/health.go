@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fbs.com/social-collector/providers"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// progress tracks what the pipeline loops are doing, for the health and
+// readiness endpoints.
+type progress struct {
+	mu       sync.Mutex
+	worker   time.Time
+	lookups  map[uint64]time.Time
+	next     uint64
+	restarts map[string]int
+	rejected map[string]int
+}
+
+var pipeline = newProgress()
+
+func newProgress() *progress {
+	return &progress{
+		lookups:  map[uint64]time.Time{},
+		restarts: map[string]int{},
+		rejected: map[string]int{},
+	}
+}
+
+// Beat records that the source, the worker loop of the postgres source, is
+// making progress.
+func (p *progress) Beat() {
+	p.mu.Lock()
+	p.worker = time.Now()
+	p.mu.Unlock()
+}
+
+// Begin records the start of a lookup and returns the id to pass to End.
+func (p *progress) Begin() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next++
+	p.lookups[p.next] = time.Now()
+	return p.next
+}
+
+func (p *progress) End(id uint64) {
+	p.mu.Lock()
+	delete(p.lookups, id)
+	p.mu.Unlock()
+}
+
+// Restarted records that loop recovered from a panic.
+func (p *progress) Restarted(loop string) {
+	p.mu.Lock()
+	p.restarts[loop]++
+	p.mu.Unlock()
+}
+
+// Responded records whether provider rejected the credentials of the last
+// request it answered.
+func (p *progress) Responded(provider string, err error) {
+	var status *providers.StatusError
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if errors.As(err, &status) && (status.Code == 401 || status.Code == 403) {
+		p.rejected[provider] = status.Code
+	} else if err == nil {
+		delete(p.rejected, provider)
+	}
+}
+
+type check struct {
+	Ok     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type checkReport struct {
+	Ok       bool             `json:"ok"`
+	Checks   map[string]check `json:"checks"`
+	Restarts map[string]int   `json:"restarts,omitempty"`
+}
+
+// Live reports whether the worker and every running lookup made progress
+// within deadline.
+func (p *progress) Live(deadline time.Duration) checkReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	report := checkReport{Ok: true, Checks: map[string]check{}, Restarts: map[string]int{}}
+
+	worker := check{Ok: now.Sub(p.worker) <= deadline}
+	if p.worker.IsZero() {
+		worker = check{Detail: "not started"}
+	} else {
+		worker.Detail = "last batch " + now.Sub(p.worker).Round(time.Millisecond).String() + " ago"
+	}
+	report.Checks["worker"] = worker
+
+	var ages []time.Duration
+	for _, started := range p.lookups {
+		ages = append(ages, now.Sub(started))
+	}
+	sort.Slice(ages, func(i, j int) bool { return ages[i] > ages[j] })
+	lookups := check{Ok: true}
+	if len(ages) > 0 {
+		lookups.Ok = ages[0] <= deadline
+		lookups.Detail = "oldest running for " + ages[0].Round(time.Millisecond).String()
+	}
+	report.Checks["lookups"] = lookups
+
+	for loop, n := range p.restarts {
+		report.Restarts[loop] = n
+	}
+	for _, c := range report.Checks {
+		report.Ok = report.Ok && c.Ok
+	}
+	return report
+}
+
+// Ready reports whether the database, when the pipeline uses one, answers
+// and no provider rejected its credentials.
+func (p *progress) Ready(ctx context.Context) checkReport {
+	report := checkReport{Ok: true, Checks: map[string]check{}}
+
+	if usesDatabase() {
+		database := check{Ok: true}
+		if dbMap == nil {
+			database = check{Detail: "not connected"}
+		} else if err := dbMap.Db.PingContext(ctx); err != nil {
+			database = check{Detail: err.Error()}
+		}
+		report.Checks["database"] = database
+	}
+
+	p.mu.Lock()
+	for provider, code := range p.rejected {
+		report.Checks[provider] = check{Detail: (&providers.StatusError{Code: code}).Error()}
+	}
+	p.mu.Unlock()
+
+	for _, c := range report.Checks {
+		report.Ok = report.Ok && c.Ok
+	}
+	return report
+}
+
+func writeReport(w http.ResponseWriter, report checkReport) {
+	w.Header().Set("Content-Type", "application/json")
+	if !report.Ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, pipeline.Live(healthDeadline()))
+}
+
+func readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	writeReport(w, pipeline.Ready(ctx))
+}
+
+// healthDeadline returns how long the loops may go without progress before
+// the process is reported unhealthy.
+func healthDeadline() time.Duration {
+	deadline := currentCfg().Http.HealthDeadline
+	if deadline <= 0 {
+		return 5 * time.Minute
+	}
+	return deadline
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fbs.com/social-collector/providers"
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+
+	Convey("Health", t, func() {
+
+		p := newProgress()
+
+		Convey("Worker that never ran is not live", func() {
+			report := p.Live(time.Minute)
+
+			So(report.Ok, ShouldBeFalse)
+			So(report.Checks["worker"].Detail, ShouldEqual, "not started")
+		})
+
+		Convey("Recent worker beat is live", func() {
+			p.Beat()
+
+			So(p.Live(time.Minute).Ok, ShouldBeTrue)
+		})
+
+		Convey("Stale worker beat is not live", func() {
+			p.worker = time.Now().Add(-2 * time.Minute)
+
+			So(p.Live(time.Minute).Ok, ShouldBeFalse)
+		})
+
+		Convey("Lookup stuck past the deadline is not live", func() {
+			p.Beat()
+			id := p.Begin()
+			p.lookups[id] = time.Now().Add(-2 * time.Minute)
+
+			So(p.Live(time.Minute).Checks["lookups"].Ok, ShouldBeFalse)
+
+			p.End(id)
+			So(p.Live(time.Minute).Ok, ShouldBeTrue)
+		})
+
+		Convey("Restarts are reported", func() {
+			p.Beat()
+			p.Restarted("worker")
+			p.Restarted("worker")
+
+			So(p.Live(time.Minute).Restarts["worker"], ShouldEqual, 2)
+		})
+
+		Convey("Rejected credentials are not ready until a success", func() {
+			cfg.Database.Driver = `testdb`
+			So(initDb(), ShouldBeNil)
+			defer dbMap.Db.Close()
+
+			So(p.Ready(context.Background()).Ok, ShouldBeTrue)
+
+			p.Responded("fullcontact", &providers.StatusError{Code: 403})
+			report := p.Ready(context.Background())
+			So(report.Ok, ShouldBeFalse)
+			So(report.Checks["fullcontact"].Detail, ShouldContainSubstring, "403")
+
+			p.Responded("fullcontact", &providers.StatusError{Code: 500})
+			So(p.Ready(context.Background()).Ok, ShouldBeFalse)
+
+			p.Responded("fullcontact", nil)
+			So(p.Ready(context.Background()).Ok, ShouldBeTrue)
+		})
+
+		Convey("Endpoints answer with JSON detail", func() {
+			pipeline.Beat()
+
+			w := httptest.NewRecorder()
+			httpMux().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+
+			var report checkReport
+			So(w.Code, ShouldEqual, 200)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			So(json.Unmarshal(w.Body.Bytes(), &report), ShouldBeNil)
+			So(report.Checks["worker"].Ok, ShouldBeTrue)
+
+			dbMap = nil
+			w = httptest.NewRecorder()
+			httpMux().ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+			So(w.Code, ShouldEqual, 503)
+			So(json.Unmarshal(w.Body.Bytes(), &report), ShouldBeNil)
+			So(report.Checks["database"].Detail, ShouldEqual, "not connected")
+		})
+
+		Convey("A pipeline without database is ready without one", func() {
+			defer func(saved types.Config) { cfg = saved }(cfg)
+			cfg.Source = types.Source{Type: "jsonl", Path: "users.jsonl"}
+			cfg.Sinks = []string{"jsonl:results.jsonl"}
+			dbMap = nil
+
+			report := p.Ready(context.Background())
+			So(report.Ok, ShouldBeTrue)
+			So(report.Checks, ShouldNotContainKey, "database")
+		})
+
+	})
+}
@@ -0,0 +1,116 @@
+// Package logger builds the structured logger of the collector. Every
+// message and attribute goes through Redact, so user emails and provider
+// API keys never reach the output.
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+(@|%40)([A-Za-z0-9\-]+\.)+[A-Za-z]{2,}`)
+	apiKeyPattern = regexp.MustCompile(`(?i)(api_?key=)[^&\s"']+`)
+)
+
+// Redact masks email addresses, keeping their domain, and the value of
+// apiKey query parameters.
+func Redact(s string) string {
+	s = apiKeyPattern.ReplaceAllString(s, "${1}REDACTED")
+	return emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		at := strings.Index(email, "@")
+		sep := 1
+		if at < 0 {
+			at = strings.Index(email, "%40")
+			sep = 3
+		}
+		return "***" + email[at:at+sep] + email[at+sep:]
+	})
+}
+
+// ParseLevel accepts debug, info, warn and error; empty means info.
+func ParseLevel(level string) (l slog.Level, err error) {
+	if level == "" {
+		return slog.LevelInfo, nil
+	}
+	err = l.UnmarshalText([]byte(level))
+	return
+}
+
+// New returns a logger writing to w in the json or logfmt format, the
+// latter being the default.
+func New(w io.Writer, format string, level string) (*slog.Logger, error) {
+
+	l, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &slog.HandlerOptions{Level: l}
+
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	case "", "logfmt", "text":
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, errors.New("New:unknown log format " + format)
+	}
+
+	return slog.New(redacting{handler}), nil
+}
+
+// redacting is a slog.Handler passing every string through Redact.
+type redacting struct {
+	slog.Handler
+}
+
+func (h redacting) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h redacting) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for i, a := range attrs {
+		attrs[i] = redactAttr(a)
+	}
+	return redacting{h.Handler.WithAttrs(attrs)}
+}
+
+func (h redacting) WithGroup(name string) slog.Handler {
+	return redacting{h.Handler.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	value := a.Value.Resolve()
+
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		attrs := make([]any, len(group))
+		for i, ga := range group {
+			attrs[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, Redact(v.String()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: value}
+}
@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"log/slog"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+
+	Convey("Logger", t, func() {
+
+		Convey("Redact masks emails and api keys", func() {
+			So(Redact("user john.doe+tag@example.com not found"), ShouldEqual, "user ***@example.com not found")
+			So(Redact("https://api.test/v2/person.json?apiKey=secret&email=john%40example.com"), ShouldEqual, "https://api.test/v2/person.json?apiKey=REDACTED&email=***%40example.com")
+			So(Redact("nothing to hide"), ShouldEqual, "nothing to hide")
+		})
+
+		Convey("Unknown format or level fails", func() {
+			_, err := New(&bytes.Buffer{}, "xml", "info")
+			So(err, ShouldNotBeNil)
+			_, err = New(&bytes.Buffer{}, "json", "loud")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Level filters messages", func() {
+			var out bytes.Buffer
+			l, err := New(&out, "logfmt", "warn")
+			So(err, ShouldBeNil)
+
+			l.Info("hidden")
+			l.Warn("shown")
+
+			So(out.String(), ShouldNotContainSubstring, "hidden")
+			So(out.String(), ShouldContainSubstring, "msg=shown")
+		})
+
+		Convey("JSON output redacts message and attributes", func() {
+			var out bytes.Buffer
+			l, err := New(&out, "json", "debug")
+			So(err, ShouldBeNil)
+
+			l.With("email", "a@b.com").WithGroup("req").Debug("lookup for c@d.org",
+				"url", "http://x/?apiKey=k&email=e%40f.net",
+				"error", errors.New("failed for g@h.io"),
+				slog.Group("user", "email", "i@j.dev"))
+
+			var entry map[string]interface{}
+			So(json.Unmarshal(out.Bytes(), &entry), ShouldBeNil)
+			So(entry["msg"], ShouldEqual, "lookup for ***@d.org")
+			So(entry["email"], ShouldEqual, "***@b.com")
+
+			req := entry["req"].(map[string]interface{})
+			So(req["url"], ShouldEqual, "http://x/?apiKey=REDACTED&email=***%40f.net")
+			So(req["error"], ShouldEqual, "failed for ***@h.io")
+			So(req["user"].(map[string]interface{})["email"], ShouldEqual, "***@j.dev")
+		})
+
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fbs.com/social-collector/providers"
+	"fbs.com/social-collector/types"
+	"flag"
+	"io"
+)
+
+// lookupReport is what the lookup subcommand prints.
+type lookupReport struct {
+	User     types.User      `json:"user"`
+	Provider string          `json:"provider"`
+	Status   string          `json:"status"`
+	Error    string          `json:"error,omitempty"`
+	Saved    bool            `json:"saved"`
+	Social   types.Social    `json:"social"`
+	Response *lookupResponse `json:"response,omitempty"`
+}
+
+type lookupResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Text   string          `json:"text,omitempty"`
+}
+
+// runLookup implements "social-collector lookup -email x@y | -user-id N
+// [-save]": it looks a single user up with the configured provider and
+// prints the result together with the raw provider response.
+func runLookup(ctx context.Context, w io.Writer, args []string) (err error) {
+
+	flags := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	flags.SetOutput(w)
+	email := flags.String("email", "", "email address to look up")
+	userId := flags.Int("user-id", 0, "id of the user to look up")
+	save := flags.Bool("save", false, "store the result like the collector does, in the configured sinks")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if *save && dryRun {
+		return errors.New("Lookup:-save writes nothing on a dry run")
+	}
+
+	var user types.User
+	switch {
+	case *userId > 0:
+		var src Source
+		if src, err = newSource(); err != nil {
+			return
+		}
+		var found bool
+		if user, found, err = src.Find(*userId); err != nil {
+			return
+		}
+		if !found {
+			return errors.New("Lookup:user not found")
+		}
+		if *email != "" {
+			user.Email = *email
+		}
+	case *email != "":
+		if *save {
+			return errors.New("Lookup:-save needs -user-id")
+		}
+		user = types.User{Email: *email}
+	default:
+		return errors.New("usage: social-collector lookup -email x@y | -user-id N [-save]")
+	}
+
+	provider, err := providers.New(cfg)
+	if err != nil {
+		return
+	}
+	// Looked up like the collector does, by canonical email, so that
+	// support sees the result it stores.
+	provider = mailboxLookups(provider)
+
+	ctx, raw := providers.WithRaw(ctx)
+
+	social, status, lookupErr := lookup(ctx, user, provider)
+
+	report := lookupReport{User: user, Provider: provider.Name(), Status: status, Social: social}
+
+	if *save {
+		var sink Sink
+		if sink, err = newSink(); err != nil {
+			return
+		}
+		defer func(previous Sink) {
+			sink.Close()
+			results = previous
+		}(results)
+		results = sink
+
+		if status == types.LookupFound {
+			mirror(ctx, &social)
+		}
+		lookupErr = persist(user, provider.Name(), &social, status, lookupErr)
+		report.Saved = lookupErr == nil && status == types.LookupFound
+		report.Social = social
+	}
+	if lookupErr != nil {
+		report.Error = lookupErr.Error()
+	}
+
+	if code, body := raw.Get(); code != 0 {
+		report.Response = &lookupResponse{Status: code}
+		if json.Valid(body) {
+			report.Response.Body = body
+		} else {
+			report.Response.Text = string(body)
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
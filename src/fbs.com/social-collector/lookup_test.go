@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fbs.com/social-collector/types"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+
+	Convey("Lookup", t, func() {
+
+		cfg.Database.Driver = `testdb`
+		err := initDb()
+		So(err, ShouldBeNil)
+		defer dbMap.Db.Close()
+		defer testdb.Reset()
+
+		var saved bool
+		var savedArgs []driver.Value
+		testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+			if strings.Contains(query, "social.users") {
+				saved = true
+				savedArgs = args
+			}
+			return testResult{1, 1}, nil
+		})
+		testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+			return testdb.RowsFromCSVString([]string{"id", "email"}, "9,db@test.com"), nil
+		})
+
+		backend := testBackend(200, `{"status":200, "socialProfiles":[{"type":"twitter", "url":"http://twitter.com/test"}]}`)
+		defer backend.Close()
+		cfg.Fullcontact.Url = backend.URL
+
+		var out bytes.Buffer
+		var report lookupReport
+
+		Convey("Usage errors", func() {
+			So(runLookup(context.Background(), &out, nil), ShouldNotBeNil)
+			So(runLookup(context.Background(), &out, []string{"-email", "x@test.com", "-save"}), ShouldNotBeNil)
+			So(runLookup(context.Background(), &out, []string{"-bogus"}), ShouldNotBeNil)
+		})
+
+		Convey("Lookup by email prints social and raw payload without saving", func() {
+			So(runLookup(context.Background(), &out, []string{"--email", "x@test.com"}), ShouldBeNil)
+			So(json.Unmarshal(out.Bytes(), &report), ShouldBeNil)
+
+			So(report.Status, ShouldEqual, "found")
+			So(report.Social.TwitterUrl, ShouldEqual, "http://twitter.com/test")
+			So(report.Response.Status, ShouldEqual, 200)
+			So(string(report.Response.Body), ShouldContainSubstring, "socialProfiles")
+			So(report.Saved, ShouldBeFalse)
+			So(saved, ShouldBeFalse)
+		})
+
+		Convey("Lookup by user id with save persists", func() {
+			So(runLookup(context.Background(), &out, []string{"--user-id", "9", "--save"}), ShouldBeNil)
+			So(json.Unmarshal(out.Bytes(), &report), ShouldBeNil)
+
+			So(report.User.Id, ShouldEqual, 9)
+			So(report.User.Email, ShouldEqual, "db@test.com")
+			So(report.Social.UserId, ShouldEqual, 9)
+			So(report.Saved, ShouldBeTrue)
+			So(saved, ShouldBeTrue)
+		})
+
+		Convey("Lookup with save mirrors the photo", func() {
+			defer func(saved types.Config) { cfg = saved }(cfg)
+
+			dir, err := ioutil.TempDir("", "photos")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			cfg.Photos = types.Photos{Enabled: true}
+			cfg.Photos.Store.Path = dir
+
+			photos := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				w.Write(testPhoto(10, 10, "png"))
+			}))
+			defer photos.Close()
+			withPhoto := testBackend(200, `{"status":200, "photos":[{"type":"twitter", "url":"`+photos.URL+`/a.png", "isPrimary":true}]}`)
+			defer withPhoto.Close()
+			cfg.Fullcontact.Url = withPhoto.URL
+
+			So(runLookup(context.Background(), &out, []string{"--user-id", "9", "--save"}), ShouldBeNil)
+			So(json.Unmarshal(out.Bytes(), &report), ShouldBeNil)
+
+			So(report.Social.PhotoKey, ShouldEndWith, ".png")
+			So(savedArgs, ShouldContain, report.Social.PhotoKey)
+		})
+
+		Convey("Provider errors are reported with the raw body", func() {
+			failing := testBackend(403, "Forbidden")
+			defer failing.Close()
+			cfg.Fullcontact.Url = failing.URL
+
+			So(runLookup(context.Background(), &out, []string{"-email", "x@test.com"}), ShouldBeNil)
+			So(json.Unmarshal(out.Bytes(), &report), ShouldBeNil)
+
+			So(report.Status, ShouldEqual, "error")
+			So(report.Error, ShouldContainSubstring, "403")
+			So(report.Response.Text, ShouldEqual, "Forbidden")
+		})
+
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fbs.com/social-collector/types"
+	"time"
+)
+
+const (
+	loadMailboxQuery = `select social, looked_up_at from social.mailbox_results where provider = $1 and email = $2`
+	saveMailboxQuery = `insert into social.mailbox_results (provider, email, social, looked_up_at) values ($1, $2, $3, $4)
+on conflict (provider, email) do update set social = excluded.social, looked_up_at = excluded.looked_up_at`
+)
+
+// mailboxStore keeps found results by canonical email in
+// social.mailbox_results, so that every user of a mailbox is served by one
+// request, across sweeps and restarts.
+type mailboxStore struct{}
+
+func (mailboxStore) Load(provider string, email string) (social types.Social, at time.Time, ok bool, err error) {
+
+	var data []byte
+	err = dbMap.Db.QueryRow(loadMailboxQuery, provider, email).Scan(&data, &at)
+	if err == sql.ErrNoRows {
+		return social, at, false, nil
+	}
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &social); err != nil {
+		return social, at, false, errors.New("Load:" + err.Error())
+	}
+	return social, at, true, nil
+}
+
+func (mailboxStore) Save(provider string, email string, social types.Social) error {
+	data, err := json.Marshal(social)
+	if err != nil {
+		return err
+	}
+	_, err = dbMap.Exec(saveMailboxQuery, provider, email, data, time.Now())
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fbs.com/social-collector/types"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// foundProvider finds a Twitter profile for every user, counting its
+// requests.
+type foundProvider struct {
+	requests int32
+}
+
+func (p *foundProvider) Name() string {
+	return "fullcontact"
+}
+
+func (p *foundProvider) Request(ctx context.Context, user types.User) (types.Social, error) {
+	atomic.AddInt32(&p.requests, 1)
+	return types.Social{UserId: user.Id, TwitterUrl: "http://twitter.com/a"}, nil
+}
+
+func TestMailbox(t *testing.T) {
+
+	Convey("Mailbox results", t, func() {
+
+		defer func(saved types.Config) { cfg = saved }(cfg)
+		cfg.Database.Driver = `testdb`
+		So(initDb(), ShouldBeNil)
+		defer dbMap.Db.Close()
+		defer testdb.Reset()
+
+		stored := map[string][]byte{}
+		testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+			if strings.Contains(query, "social.mailbox_results") {
+				stored[args[0].(string)+"/"+args[1].(string)] = args[2].([]byte)
+			}
+			return testResult{1, 1}, nil
+		})
+		testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+			data, ok := stored[args[0].(string)+"/"+args[1].(string)]
+			if !ok {
+				return testdb.RowsFromSlice([]string{"social", "looked_up_at"}, nil), nil
+			}
+			return testdb.RowsFromSlice([]string{"social", "looked_up_at"}, [][]driver.Value{{data, time.Now()}}), nil
+		})
+
+		Convey("Saves and loads results by provider and email", func() {
+			_, _, ok, err := mailboxStore{}.Load("fullcontact", "a@test.ru")
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeFalse)
+
+			social := types.Social{UserId: 3, TwitterUrl: "http://twitter.com/a", Profiles: []types.Profile{{Network: "twitter", Url: "http://twitter.com/a"}}}
+			So(mailboxStore{}.Save("fullcontact", "a@test.ru", social), ShouldBeNil)
+
+			loaded, at, ok, err := mailboxStore{}.Load("fullcontact", "a@test.ru")
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(at, ShouldNotBeZeroValue)
+			So(loaded, ShouldResemble, social)
+		})
+
+		Convey("Users of one mailbox looked up far apart cost one request", func() {
+			counting := &foundProvider{}
+
+			cfg.Emails.Cache.TTL = 0
+			social, err := mailboxLookups(counting).Request(context.Background(), types.User{Id: 1, Email: "a.b@gmail.com"})
+			So(err, ShouldBeNil)
+			So(social.TwitterUrl, ShouldEqual, "http://twitter.com/a")
+
+			// A restart, with a new cache.
+			social, err = mailboxLookups(counting).Request(context.Background(), types.User{Id: 2, Email: "AB+news@googlemail.com"})
+			So(err, ShouldBeNil)
+			So(social.UserId, ShouldEqual, 2)
+			So(social.TwitterUrl, ShouldEqual, "http://twitter.com/a")
+			So(atomic.LoadInt32(&counting.requests), ShouldEqual, 1)
+		})
+	})
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fbs.com/social-collector/emails"
+	"fbs.com/social-collector/logger"
+	"fbs.com/social-collector/metrics"
+	"fbs.com/social-collector/migrations"
 	"fbs.com/social-collector/providers"
 	"fbs.com/social-collector/types"
 	"flag"
@@ -10,13 +16,20 @@ import (
 	_ "github.com/lib/pq"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 var (
 	configUrl string
+	dryRun    bool
 	cfg       types.Config
+	cfgMu     sync.RWMutex
 	dbMap     *gorp.DbMap
 )
 
@@ -27,118 +40,682 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	err = initDb()
+	err = initLogger()
 	if err != nil {
 		panic(err)
 	}
+	command := flag.Arg(0)
+	if (command != "" && command != "lookup") || usesDatabase() {
+		err = initDb()
+		if err != nil {
+			panic(err)
+		}
+		defer dbMap.Db.Close()
+	}
+
+	switch command {
+	case "":
+		err = run()
+	case "migrate":
+		err = runMigrate(os.Stdout, flag.Args()[1:])
+	case "lookup":
+		err = runLookup(context.Background(), os.Stdout, flag.Args()[1:])
+	case "checkpoint":
+		err = runCheckpoint(os.Stdout, flag.Args()[1:])
+	case "notify":
+		err = runNotify(os.Stdout, flag.Args()[1:])
+	default:
+		err = errors.New("unknown command " + command)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// run refuses to start against an out-of-date schema, then runs the
+// pipeline until SIGINT or SIGTERM.
+func run() (err error) {
+
+	if usesDatabase() {
+		err = migrations.Check(dbMap.Db)
+		if err != nil {
+			return
+		}
+	}
 
-	start()
+	src, err := newSource()
+	if err != nil {
+		return
+	}
+	err = src.Check()
+	if err != nil {
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if cfg.Http.Listen != "" {
+		go serveHttp(ctx, cfg.Http.Listen, httpMux())
+	}
+	if cfg.Api.Listen != "" {
+		go serveHttp(ctx, cfg.Api.Listen, apiMux())
+	}
+
+	return start(ctx)
 }
-func start() {
 
-	var messages = make(chan types.User, 1)
+// start runs the pipeline until ctx is done, or a finite source has been
+// read and all of its users looked up. Users already fetched are still
+// looked up for up to the shutdown grace period; lookups left after that are
+// cancelled and picked up again by the next run.
+func start(ctx context.Context) (err error) {
+
+	src, err := newSource()
+	if err != nil {
+		return
+	}
+
+	current, err := providers.New(cfg)
+	if err != nil {
+		return
+	}
+	provider := providers.NewSwappable(current)
+
+	lookups := mailboxLookups(provider)
+
+	sink, err := newSink()
+	if err != nil {
+		return
+	}
+	defer func(previous Sink) {
+		if err := sink.Close(); err != nil {
+			slog.Error("close sink", "sink", sink.Name(), "error", err)
+		}
+		results = previous
+	}(results)
+	results = sink
+
+	var messages = make(chan types.User, concurrency())
+
+	queue := newRequeue(&messages, cfg.Requeue.Attempts, cfg.Requeue.Delay)
+
+	ctx, finished := context.WithCancel(ctx)
+	defer finished()
+
+	enrichQueue.Attach(ctx, &messages)
+
+	var sourceErr error
+	go func() {
+		sourceErr = src.Run(ctx, feed{messages: &messages, queue: queue, provider: provider.Name()})
+		if sourceErr != nil {
+			slog.Error("read source", "source", src.Name(), "error", sourceErr)
+		} else if ctx.Err() == nil {
+			queue.Wait(ctx)
+			slog.Info("source exhausted", "source", src.Name())
+		}
+		finished()
+
+		enrichQueue.Detach()
+		for _, user := range queue.Close() {
+			retryLater(user, provider.Name())
+		}
+		close(messages)
+	}()
+
+	lookupCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-lookupCtx.Done():
+			return
+		}
+		grace := currentCfg().ShutdownGrace
+		slog.Info("shutting down", "grace", grace)
+		select {
+		case <-time.After(grace):
+			cancel()
+		case <-lookupCtx.Done():
+		}
+	}()
+
+	pool := newListeners(lookupCtx, &messages, lookups, queue)
+	pool.Resize(concurrency())
 
-	go workerLoop(&messages)
+	watching := make(chan struct{})
+	go func() {
+		watchReloads(ctx, &reloader{provider: provider, pool: pool, queue: queue})
+		close(watching)
+	}()
 
-	listenLoop(&messages)
+	// messages is only closed once ctx is done, which stops the watch too.
+	pool.Wait()
+	<-watching
 
+	return sourceErr
+}
+
+// mailboxLookups wraps provider so that users sharing a mailbox are looked
+// up once, whichever provider is swapped in: at the same time through the
+// cache and, when the database is written, later on from the results saved
+// to it for the profile ttl.
+func mailboxLookups(provider providers.Provider) providers.Provider {
+	c := currentCfg()
+	if usesDatabase() && !dryRun {
+		provider = providers.WithStore(provider, mailboxStore{}, c.TTL)
+	}
+	return providers.WithCache(provider, emails.New(c.Emails.Rules).Canonical, c.Emails.Cache.TTL, c.Emails.Cache.Size)
 }
 
 func init() {
 	flag.StringVar(&configUrl, "config", "cfg/config.yml", "a string")
+	flag.BoolVar(&dryRun, "dry-run", false, "look users up and print the results instead of writing them")
 }
 
 func initCfg() (err error) {
+	cfg, err = loadCfg()
+	return
+}
+
+// loadCfg reads the file at configUrl, applies the environment overrides
+// and validates the result.
+func loadCfg() (c types.Config, err error) {
 
 	data, err := ioutil.ReadFile(configUrl)
 	if err != nil {
 		return
 	}
 
-	err = yaml.Unmarshal(data, &cfg)
+	if err = yaml.Unmarshal(data, &c); err != nil {
+		return
+	}
+
+	if err = c.ApplyEnv(types.EnvPrefix, os.LookupEnv); err != nil {
+		return
+	}
+
+	err = validateCfg(c)
+	return
+}
+
+// usesDatabase reports whether the pipeline reads or writes the database:
+// with a postgres source, or a postgres sink outside of a dry run. A file
+// source with file sinks runs without one.
+func usesDatabase() bool {
+	return needsDatabase(currentCfg())
+}
+
+// needsDatabase is usesDatabase for the config c.
+func needsDatabase(c types.Config) bool {
+	if c.Source.Type == "" || c.Source.Type == defaultSourceType {
+		return true
+	}
+	if dryRun {
+		return false
+	}
+	sinks := c.Sinks
+	if len(sinks) == 0 {
+		sinks = []string{defaultSink}
+	}
+	for _, sink := range sinks {
+		if sink == "postgres" {
+			return true
+		}
+	}
+	return false
+}
+
+// currentCfg returns the config in effect, which a reload may replace while
+// the pipeline runs.
+func currentCfg() types.Config {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return cfg
+}
+
+// validateCfg checks c, including that the configured provider exists and,
+// when the pipeline uses one, the database settings, and reports every
+// problem at once.
+func validateCfg(c types.Config) error {
+
+	problems, _ := c.Validate().(types.ConfigError)
+	if needsDatabase(c) {
+		problems = append(problems, c.DatabaseProblems()...)
+	}
+
+	// Users of a file are mostly unknown to the source table, and the
+	// postgres sink has nowhere to store them.
+	if (c.Source.Type == "jsonl" || c.Source.Type == "csv") && !dryRun {
+		fileSink := false
+		for _, sink := range c.Sinks {
+			fileSink = fileSink || sink != "postgres"
+		}
+		if !fileSink {
+			problems = append(problems, "sinks must include stdout, jsonl:path or csv:path with a "+c.Source.Type+" source, postgres only stores users with an id")
+		}
+	}
+
+	name := c.Provider
+	if name == "" {
+		name = providers.DefaultProvider
+	}
+	known := false
+	for _, registered := range providers.Names() {
+		known = known || registered == name
+	}
+	if !known {
+		problems = append(problems, "provider must be one of "+strings.Join(providers.Names(), ", ")+", got "+name)
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func initLogger() (err error) {
+
+	c := currentCfg()
+	l, err := logger.New(os.Stderr, c.Log.Format, c.Log.Level)
+	if err != nil {
+		return
+	}
+	slog.SetDefault(l)
 	return
 }
 
+// sqlTracer sends the gorp query trace to the debug log.
+type sqlTracer struct{}
+
+func (sqlTracer) Printf(format string, v ...interface{}) {
+	slog.Debug("sql", "query", strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+
 func initDb() (err error) {
 
-	db, err := sql.Open(cfg.Database.Driver, generateDataSourceName())
-	if err != nil || db.Ping() != nil {
+	c := currentCfg()
+	db, err := sql.Open(c.Database.Driver, generateDataSourceName(c))
+	if err != nil {
 		return
 	}
+	if err = pingDb(db); err != nil {
+		db.Close()
+		return errors.New("initDb:database unreachable: " + err.Error())
+	}
 	dbMap = &gorp.DbMap{Db: db, Dialect: gorp.PostgresDialect{}}
-	dbMap.TraceOn("[gorp]", log.New(os.Stdout, "myapp:", log.Lmicroseconds))
+	if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		dbMap.TraceOn("", sqlTracer{})
+	}
 	dbMap.AddTableWithName(types.Social{}, `social"."users`)
 	return
 }
-func listenLoop(messages *chan types.User) {
+
+// maxConnectDelay caps the backoff between database connection attempts.
+const maxConnectDelay = 30 * time.Second
+
+// pingDb waits for db to answer, trying up to database.connect.attempts
+// times with a delay doubling from database.connect.delay.
+func pingDb(db *sql.DB) (err error) {
+
+	attempts := cfg.Database.Connect.Attempts
+	delay := cfg.Database.Connect.Delay
+
+	for attempt := 1; ; attempt++ {
+		if err = db.Ping(); err == nil || attempt >= attempts {
+			return
+		}
+
+		slog.Warn("database unreachable", "attempt", attempt, "delay", delay, "error", err)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxConnectDelay {
+			delay = maxConnectDelay
+		}
+	}
+}
+
+// providerName returns the name of the configured provider.
+func providerName() string {
+	if name := currentCfg().Provider; name != "" {
+		return name
+	}
+	return providers.DefaultProvider
+}
+
+// concurrency returns the number of lookups run in parallel.
+func concurrency() int {
+	if c := currentCfg(); c.Concurrency > 1 {
+		return c.Concurrency
+	}
+	return 1
+}
+
+// listeners is a resizable pool of listenLoop goroutines.
+type listeners struct {
+	ctx      context.Context
+	messages *chan types.User
+	provider providers.Provider
+	queue    *requeue
+	mu       sync.Mutex
+	stops    []chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newListeners(ctx context.Context, messages *chan types.User, provider providers.Provider, queue *requeue) *listeners {
+	return &listeners{ctx: ctx, messages: messages, provider: provider, queue: queue}
+}
+
+// Resize starts or stops listeners until size of them run. A stopped
+// listener finishes the lookup it is running first.
+func (l *listeners) Resize(size int) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for len(l.stops) < size {
+		stop := make(chan struct{})
+		l.stops = append(l.stops, stop)
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			listenLoop(l.ctx, l.messages, l.provider, l.queue, stop)
+		}()
+	}
+	for len(l.stops) > size {
+		last := len(l.stops) - 1
+		close(l.stops[last])
+		l.stops = l.stops[:last]
+	}
+}
+
+// Wait returns once every listener has returned, i.e. messages is closed
+// and drained.
+func (l *listeners) Wait() {
+	l.wg.Wait()
+}
+
+// listenLoop looks up the users received on messages until the channel is
+// closed or stop is. Once ctx is done the remaining users are drained
+// without lookups.
+func listenLoop(ctx context.Context, messages *chan types.User, provider providers.Provider, queue *requeue, stop <-chan struct{}) {
 
 	defer func() {
 		if r := recover(); r != nil {
-			listenLoop(messages)
+			slog.Error("listen loop panic", "panic", fmt.Sprint(r))
+			pipeline.Restarted("listener")
+			listenLoop(ctx, messages, provider, queue, stop)
 		}
 	}()
 
-	var provider = providers.Fullcontact{Url: cfg.Fullcontact.Url, ApiKey: cfg.Fullcontact.ApiKey}
-
 	for {
-		user := <-*messages
-		err := search(user, &provider)
+		var user types.User
+		select {
+		case <-stop:
+			return
+		case received, ok := <-*messages:
+			if !ok {
+				return
+			}
+			user = received
+		}
+		metrics.QueueDepth.Set(float64(len(*messages)))
+
+		if ctx.Err() != nil {
+			queue.Done(user)
+			continue
+		}
+
+		err := search(ctx, user, provider)
+
+		var queued *providers.QueuedError
+		if errors.As(err, &queued) {
+			if queue.Schedule(user, queued.After) {
+				continue
+			}
+			retryLater(user, provider.Name())
+		}
+		queue.Done(user)
+
 		if err != nil {
-			log.Printf("%s", err)
+			slog.Warn("lookup failed", "provider", provider.Name(), "user_id", user.Id, "error", err)
 		}
 	}
 }
 
-func search(user types.User, provider *providers.Fullcontact) (err error) {
+// search looks user up with provider, mirrors the photo found and stores
+// the result.
+func search(ctx context.Context, user types.User, provider providers.Provider) (err error) {
+
+	social, status, err := lookup(ctx, user, provider)
+	if status == types.LookupFound {
+		mirror(ctx, &social)
+	}
+
+	return persist(user, provider.Name(), &social, status, err)
+}
+
+// lookup runs provider for user and classifies the outcome.
+func lookup(ctx context.Context, user types.User, provider providers.Provider) (social types.Social, status string, err error) {
 
-	social, err := provider.Request(user)
+	id := pipeline.Begin()
+	started := time.Now()
+	social, err = provider.Request(ctx, user)
+	metrics.LookupDuration.WithLabelValues(provider.Name()).Observe(time.Since(started).Seconds())
+	pipeline.End(id)
+	pipeline.Responded(provider.Name(), err)
 
-	if err == nil && social.IsValid() == nil {
-		err = dbMap.Insert(&social)
+	status = lookupStatus(social, err)
+	if status == types.LookupNotFound {
+		err = nil
 	}
 	return
 }
 
-func workerLoop(messages *chan types.User) {
+// persist writes the outcome of a lookup to the sinks. It returns the
+// lookup error, or the write error when writing failed.
+func persist(user types.User, provider string, social *types.Social, status string, err error) error {
+
+	now := time.Now()
+	if status == types.LookupFound {
+		social.UpdatedAt = now
+	}
+
+	if writeErr := results.Write(result{User: user, Provider: provider, Status: status, Social: *social, Err: err, At: now}); writeErr != nil {
+		err = writeErr
+		status = types.LookupError
+	}
+	metrics.Lookups.WithLabelValues(provider, status).Inc()
+
+	enrichQueue.Finished(user.Id, status, *social, err)
+	return err
+}
+
+const saveSocialQuery = `insert into social.users (user_id, facebook_url, twitter_url, photo_url, updated_at, photo_key) values ($1, $2, $3, $4, $5, $6)
+on conflict (user_id) do update set facebook_url = excluded.facebook_url, twitter_url = excluded.twitter_url, photo_url = excluded.photo_url, updated_at = excluded.updated_at,
+photo_key = case when excluded.photo_key = '' and excluded.photo_url = social.users.photo_url then social.users.photo_key else excluded.photo_key end`
+
+const (
+	deleteProfilesQuery = `delete from social.profiles where user_id = $1`
+	saveProfileQuery    = `insert into social.profiles (user_id, network, username, profile_id, url, bio, followers, following, updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+on conflict (user_id, network, url) do update set username = excluded.username, profile_id = excluded.profile_id, bio = excluded.bio, followers = excluded.followers, following = excluded.following, updated_at = excluded.updated_at`
+)
+
+// saveSocial inserts social or refreshes the existing row of the user, and
+// replaces the profiles of the user with those of social, in one
+// transaction. A photo left without key, its mirror having failed, keeps
+// the key stored for the same url.
+func saveSocial(social *types.Social) (err error) {
+
+	social.UpdatedAt = time.Now()
+
+	tx, err := dbMap.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(deleteProfilesQuery, social.UserId); err != nil {
+		return
+	}
+	for _, p := range social.Profiles {
+		_, err = tx.Exec(saveProfileQuery, social.UserId, p.Network, p.Username, p.ProfileId, p.Url, p.Bio, p.Followers, p.Following, social.UpdatedAt)
+		if err != nil {
+			return
+		}
+	}
+	if _, err = tx.Exec(saveSocialQuery, social.UserId, social.FacebookUrl, social.TwitterUrl, social.PhotoUrl, social.UpdatedAt, social.PhotoKey); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// workerLoop sweeps the source until ctx is done. A dry run sweeps it once,
+// from the first user.
+func workerLoop(ctx context.Context, f feed) {
 
 	defer func() {
 		if r := recover(); r != nil {
-			workerLoop(messages)
+			slog.Error("worker loop panic", "panic", fmt.Sprint(r))
+			pipeline.Restarted("worker")
+			workerLoop(ctx, f)
 		}
 	}()
 
-	maxId := 0
+	if dryRun {
+		maxId := 0
+		for ctx.Err() == nil {
+			worker(ctx, f, &maxId)
+			if maxId == 0 {
+				return
+			}
+		}
+		return
+	}
 
-	for {
-		worker(messages, &maxId)
+	maxId := resumeCheckpoint(source().Table, f.provider)
+
+	notifications := listenNotify(ctx)
+	if notifications != nil {
+		defer notifications.Close()
+	}
+
+	for ctx.Err() == nil {
+		worker(ctx, f, &maxId)
+
+		// With notifications new users arrive on their own, so the
+		// source is only swept again once the interval has passed.
+		if notifications == nil {
+			continue
+		}
+		if maxId == 0 {
+			notifications.Wait(ctx, f.messages, f.provider, notifySweep())
+		} else {
+			notifications.Drain(ctx, f.messages, f.provider)
+		}
 	}
 
 }
-func worker(messages *chan types.User, maxId *int) {
-	var users []types.User
 
-	_, err := dbMap.Select(&users, "select u.id, u.email from personal_area.user as u left join social.users as su on su.user_id = u.id where u.email is not null  and su.user_id is null and u.id > :maxId order by u.id limit 100", map[string]interface{}{
-		"maxId": *maxId,
-	})
+// worker sends the next batch of source users without social profiles, or
+// whose profiles are older than the configured TTL, through f. Users
+// already looked up by the provider of f within the cool-down are skipped,
+// unless the lookup failed.
+func worker(ctx context.Context, f feed, maxId *int) {
+
+	pipeline.Beat()
+
+	provider := f.provider
+	users, err := selectPending(provider, "u.id > :maxId", map[string]interface{}{"maxId": *maxId})
 
 	if err != nil {
-		log.Printf("Select from db:%s", err)
+		slog.Error("select users", "error", err)
 		return
 	}
 
-	if len(users) > 0 {
+	metrics.BatchSize.Observe(float64(len(users)))
+
+	if len(users) == 0 {
+		if *maxId == 0 {
+			return
+		}
+		*maxId = 0
+	} else {
 
 		*maxId = users[len(users)-1].Id
 
 		for _, user := range users {
-			*messages <- user
+			if !f.Send(ctx, user) {
+				return
+			}
 		}
+	}
 
-	} else {
-		*maxId = 0
+	if dryRun {
+		return
+	}
+	saveProgress(ctx, f, *maxId)
+}
+
+// saveProgress checkpoints the worker of f at maxId, or just before the
+// first user sent through f whose lookup is still outstanding, so that a
+// restart sends that user again. Nothing is saved once ctx is done: the
+// listeners then drop the users they still hold without looking them up.
+func saveProgress(ctx context.Context, f feed, maxId int) {
+
+	if first, ok := f.queue.First(); ok && first <= maxId {
+		maxId = first - 1
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	table := source().Table
+	if err := saveCheckpoint(table, f.provider, maxId); err != nil {
+		slog.Error("save checkpoint", "source", table, "provider", f.provider, "error", err)
+	}
+}
+
+// selectPending returns up to a batch of source users matching cursor, a
+// condition on u.id using params, that have no social profiles or stale
+// ones. Users looked up by provider within the cool-down are skipped, unless
+// the lookup failed.
+func selectPending(provider string, cursor string, params map[string]interface{}) (users []types.User, err error) {
+
+	s := source()
+
+	params["provider"] = provider
+	params["error"] = types.LookupError
+	params["since"] = time.Now().Add(-currentCfg().Cooldown)
+	params["stale"] = staleBefore()
+	params["batch"] = s.Batch
+
+	_, err = dbMap.Select(&users, `select u.id, u.email from `+sourceTable(s)+` as u
+left join social.users as su on su.user_id = u.id
+where (su.user_id is null or su.updated_at < :stale) and `+cursor+`
+and not exists (select 1 from social.lookup_attempts as la where la.user_id = u.id and la.provider = :provider and la.status <> :error and la.attempted_at > :since)
+order by u.id limit :batch`, params)
+	return
+}
+
+// staleBefore returns the instant before which social profiles are looked up
+// again. A zero TTL never refreshes them.
+func staleBefore() time.Time {
+	ttl := currentCfg().TTL
+	if ttl <= 0 {
+		return time.Time{}
 	}
+	return time.Now().Add(-ttl)
 }
 
-func generateDataSourceName() string {
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", cfg.Database.Host, cfg.Database.Port, cfg.Database.Username, cfg.Database.Password, cfg.Database.Database)
+// generateDataSourceName returns the connection string of the database of
+// c. Callers pass currentCfg(), as a reload may replace cfg meanwhile.
+func generateDataSourceName(c types.Config) string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", c.Database.Host, c.Database.Port, c.Database.Username, c.Database.Password, c.Database.Database)
 }
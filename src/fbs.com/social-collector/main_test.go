@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"database/sql/driver"
-	_ "errors"
+	"errors"
 	"fbs.com/social-collector/providers"
 	"fbs.com/social-collector/types"
 	"github.com/erikstmartin/go-testdb"
 	. "github.com/smartystreets/goconvey/convey"
+	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type testResult struct {
@@ -26,6 +34,22 @@ func (r testResult) RowsAffected() (int64, error) {
 	return r.affectedRows, nil
 }
 
+type countingProvider struct {
+	mu    sync.Mutex
+	users []int
+}
+
+func (p *countingProvider) Name() string {
+	return "counting"
+}
+
+func (p *countingProvider) Request(ctx context.Context, user types.User) (types.Social, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users = append(p.users, user.Id)
+	return types.Social{UserId: user.Id}, nil
+}
+
 func testBackend(response_code int, payload string) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
@@ -34,6 +58,49 @@ func testBackend(response_code int, payload string) *httptest.Server {
 		}))
 }
 
+// keepLogger returns a func restoring the default logger. slog.SetDefault
+// also redirects the log package, which restoring slog alone does not undo.
+func keepLogger() func() {
+	logger, writer, flags := slog.Default(), log.Writer(), log.Flags()
+	return func() {
+		slog.SetDefault(logger)
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+	}
+}
+
+// validConfig returns a config passing validateCfg.
+func validConfig() (c types.Config) {
+	c.Provider = "fullcontact"
+	c.Fullcontact.ApiKey = "key"
+	c.Fullcontact.Url = "https://api.fullcontact.com/v2/person.json"
+	c.Database.Driver = "postgres"
+	c.Database.Host = "localhost"
+	c.Database.Database = "social"
+	c.Database.Username = "collector"
+	c.Database.Port = 5432
+	return
+}
+
+// testFeed feeds messages as the fullcontact provider.
+func testFeed(messages *chan types.User) feed {
+	return feed{messages: messages, queue: newRequeue(messages, 0, 0), provider: "fullcontact"}
+}
+
+// unreachableDriver fails every connection, like a database that is down.
+type unreachableDriver struct{}
+
+var unreachableOpens int32
+
+func (unreachableDriver) Open(name string) (driver.Conn, error) {
+	atomic.AddInt32(&unreachableOpens, 1)
+	return nil, errors.New("connection refused")
+}
+
+func init() {
+	sql.Register("unreachable", unreachableDriver{})
+}
+
 func TestWorker(t *testing.T) {
 
 	Convey("Main", t, func() {
@@ -61,9 +128,10 @@ func TestWorker(t *testing.T) {
 
 					provider := providers.Fullcontact{Url: backend.URL, ApiKey: cfg.Fullcontact.ApiKey}
 
-					err := search(user, &provider)
+					err := search(context.Background(), user, provider)
 
-					if code == 200 {
+					// 404 is the answer for an unknown person.
+					if code == 200 || code == 404 {
 						So(err, ShouldBeNil)
 					} else {
 						So(err, ShouldNotBeNil)
@@ -96,7 +164,7 @@ func TestWorker(t *testing.T) {
 						return testdb.RowsFromCSVString(columns, rows), nil
 					})
 
-					go worker(&messages, &maxId)
+					go worker(context.Background(), testFeed(&messages), &maxId)
 
 					user := <-messages
 
@@ -118,7 +186,7 @@ func TestWorker(t *testing.T) {
 						return testdb.RowsFromCSVString(columns, rows), nil
 					})
 
-					go worker(&messages, &maxId)
+					go worker(context.Background(), testFeed(&messages), &maxId)
 
 					So(maxId, ShouldEqual, 0)
 					So(len(messages), ShouldEqual, 0)
@@ -128,6 +196,144 @@ func TestWorker(t *testing.T) {
 				})
 			})
 
+			Convey("Check listeners", func() {
+
+				messages := make(chan types.User, 3)
+				messages <- types.User{Id: 1, Email: "1@test.com"}
+				messages <- types.User{Id: 2, Email: "2@test.com"}
+				messages <- types.User{Id: 3, Email: "3@test.com"}
+				close(messages)
+
+				provider := &countingProvider{}
+
+				pool := newListeners(context.Background(), &messages, provider, newRequeue(&messages, 0, 0))
+				pool.Resize(2)
+				pool.Wait()
+
+				So(provider.users, ShouldHaveLength, 3)
+				So(provider.users, ShouldContain, 1)
+				So(provider.users, ShouldContain, 2)
+				So(provider.users, ShouldContain, 3)
+
+			})
+
+			Convey("Check queued lookup is requeued and persisted", func() {
+
+				var calls int32
+				backend := httptest.NewServer(http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						if atomic.AddInt32(&calls, 1) == 1 {
+							w.WriteHeader(202)
+							return
+						}
+						w.WriteHeader(200)
+						w.Write([]byte(`{"status":200, "socialProfiles":[{"type":"facebook", "url":"http://test.com"}]}`))
+					}))
+				defer backend.Close()
+
+				inserted := make(chan []driver.Value, 1)
+				testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (result driver.Result, err error) {
+					if strings.Contains(query, "social.users") {
+						inserted <- args
+					}
+					return testResult{1, 1}, nil
+				})
+				defer testdb.Reset()
+
+				messages := make(chan types.User, 1)
+				queue := newRequeue(&messages, 1, 10*time.Millisecond)
+				provider := providers.Fullcontact{Url: backend.URL, ApiKey: "1"}
+
+				go listenLoop(context.Background(), &messages, provider, queue, nil)
+				messages <- types.User{Id: 5, Email: "test@test.com"}
+
+				select {
+				case args := <-inserted:
+					So(args[0], ShouldEqual, 5)
+				case <-time.After(time.Second):
+					So("timeout", ShouldBeEmpty)
+				}
+				So(atomic.LoadInt32(&calls), ShouldEqual, 2)
+
+				close(messages)
+
+			})
+
+			Convey("Check saveSocial func upserts", func() {
+
+				var saved string
+				var args []driver.Value
+				var profiles [][]driver.Value
+				var deleted bool
+				testdb.SetExecWithArgsFunc(func(query string, a []driver.Value) (result driver.Result, err error) {
+					switch {
+					case strings.HasPrefix(query, "delete from social.profiles"):
+						deleted = len(profiles) == 0
+					case strings.Contains(query, "social.profiles"):
+						profiles = append(profiles, a)
+					default:
+						saved = query
+						args = a
+					}
+					return testResult{1, 1}, nil
+				})
+				defer testdb.Reset()
+
+				social := types.Social{UserId: 3, TwitterUrl: "http://twitter.com/test", Profiles: []types.Profile{
+					{Network: "twitter", Url: "http://twitter.com/test"},
+					{Network: "github", Username: "test", Url: "https://github.com/test", Followers: 12},
+				}}
+				err := saveSocial(&social)
+
+				So(err, ShouldBeNil)
+				So(saved, ShouldContainSubstring, "on conflict (user_id) do update")
+				So(args[0], ShouldEqual, 3)
+				So(args[2], ShouldEqual, "http://twitter.com/test")
+				So(social.UpdatedAt.IsZero(), ShouldBeFalse)
+
+				So(deleted, ShouldBeTrue)
+				So(profiles, ShouldHaveLength, 2)
+				So(profiles[1][:3], ShouldResemble, []driver.Value{int64(3), "github", "test"})
+				So(profiles[1][6], ShouldEqual, 12)
+			})
+
+			Convey("Check saveSocial rolls back on error", func() {
+
+				var rolledBack bool
+				testdb.SetExecWithArgsFunc(func(query string, a []driver.Value) (result driver.Result, err error) {
+					if strings.Contains(query, "social.users") {
+						return nil, errors.New("insert failed")
+					}
+					return testResult{1, 1}, nil
+				})
+				testdb.SetRollbackFunc(func() error {
+					rolledBack = true
+					return nil
+				})
+				defer testdb.Reset()
+
+				social := types.Social{UserId: 3, Profiles: []types.Profile{{Network: "github", Url: "https://github.com/test"}}}
+				So(saveSocial(&social), ShouldNotBeNil)
+				So(rolledBack, ShouldBeTrue)
+
+			})
+
+			Convey("Check staleBefore func", func() {
+				cfg.TTL = 0
+				So(staleBefore().IsZero(), ShouldBeTrue)
+				cfg.TTL = time.Hour
+				So(staleBefore(), ShouldHappenBefore, time.Now().Add(-59*time.Minute))
+				cfg.TTL = 0
+			})
+
+			Convey("Check concurrency func", func() {
+				cfg.Concurrency = 0
+				So(concurrency(), ShouldEqual, 1)
+				cfg.Concurrency = 4
+				So(concurrency(), ShouldEqual, 4)
+				cfg.Concurrency = 0
+			})
+
 			Convey("Check start func", func() {
 
 				Convey("Run with user", func() {
@@ -147,8 +353,62 @@ func TestWorker(t *testing.T) {
 					defer backend.Close()
 
 					cfg.Fullcontact.Url = backend.URL
+					cfg.ShutdownGrace = 100 * time.Millisecond
 
-					go start()
+					ctx, cancel := context.WithCancel(context.Background())
+					stopped := make(chan error)
+
+					go func() {
+						stopped <- start(ctx)
+					}()
+
+					time.Sleep(50 * time.Millisecond)
+					cancel()
+
+					select {
+					case err := <-stopped:
+						So(err, ShouldBeNil)
+					case <-time.After(time.Second):
+						So("timeout", ShouldBeEmpty)
+					}
+
+					testdb.Reset()
+
+				})
+
+				Convey("Cancel in-flight lookups after the grace period", func() {
+
+					testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (result driver.Rows, err error) {
+						return testdb.RowsFromCSVString([]string{"id", "email"}, "2,test@test.ru"), nil
+					})
+					testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (result driver.Result, err error) {
+						return testResult{1, 1}, nil
+					})
+					backend := httptest.NewServer(http.HandlerFunc(
+						func(w http.ResponseWriter, r *http.Request) {
+							<-r.Context().Done()
+						}))
+					defer backend.Close()
+
+					cfg.Fullcontact.Url = backend.URL
+					cfg.ShutdownGrace = 100 * time.Millisecond
+
+					ctx, cancel := context.WithCancel(context.Background())
+					stopped := make(chan error)
+
+					go func() {
+						stopped <- start(ctx)
+					}()
+
+					time.Sleep(50 * time.Millisecond)
+					cancel()
+
+					select {
+					case err := <-stopped:
+						So(err, ShouldBeNil)
+					case <-time.After(time.Second):
+						So("timeout", ShouldBeEmpty)
+					}
 
 					testdb.Reset()
 
@@ -208,6 +468,96 @@ func TestWorker(t *testing.T) {
 				})
 			})
 
+			Convey("Check validateCfg", func() {
+				err := validateCfg(types.Config{Provider: "nobody"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "database.host is empty")
+				So(err.Error(), ShouldContainSubstring, "provider must be one of fullcontact, got nobody")
+			})
+
+			Convey("Check validateCfg only requires the database when it is used", func() {
+				c := validConfig()
+				c.Database = types.Config{}.Database
+				c.Source = types.Source{Type: "csv", Path: "users.csv"}
+				c.Sinks = []string{"jsonl:results.jsonl"}
+				So(validateCfg(c), ShouldBeNil)
+
+				c.Sinks = append(c.Sinks, "postgres")
+				err := validateCfg(c)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "database.host is empty")
+			})
+
+			Convey("Check validateCfg requires a file sink for a file source", func() {
+				defer func(saved bool) { dryRun = saved }(dryRun)
+
+				c := validConfig()
+				c.Source = types.Source{Type: "jsonl", Path: "users.jsonl"}
+				err := validateCfg(c)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "sinks must include stdout, jsonl:path or csv:path with a jsonl source")
+
+				c.Sinks = []string{"postgres"}
+				So(validateCfg(c), ShouldNotBeNil)
+
+				dryRun = true
+				So(validateCfg(c), ShouldBeNil)
+
+				dryRun = false
+				c.Sinks = []string{"postgres", "csv:-"}
+				So(validateCfg(c), ShouldBeNil)
+			})
+
+			Convey("Check usesDatabase", func() {
+				defer func(saved types.Config, savedDryRun bool) { cfg, dryRun = saved, savedDryRun }(cfg, dryRun)
+
+				cfg.Source = types.Source{}
+				cfg.Sinks = nil
+				dryRun = true
+				So(usesDatabase(), ShouldBeTrue)
+
+				cfg.Source = types.Source{Type: "csv", Path: "users.csv"}
+				So(usesDatabase(), ShouldBeFalse)
+
+				dryRun = false
+				So(usesDatabase(), ShouldBeTrue)
+
+				cfg.Sinks = []string{"jsonl:results.jsonl", "csv:-"}
+				So(usesDatabase(), ShouldBeFalse)
+
+				cfg.Sinks = append(cfg.Sinks, "postgres")
+				So(usesDatabase(), ShouldBeTrue)
+			})
+
+			Convey("Check initDb retries an unreachable database", func() {
+				defer func(saved types.Config) { cfg = saved }(cfg)
+
+				atomic.StoreInt32(&unreachableOpens, 0)
+				cfg.Database.Driver = "unreachable"
+				cfg.Database.Connect.Attempts = 3
+				cfg.Database.Connect.Delay = time.Millisecond
+
+				err := initDb()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "initDb:database unreachable: connection refused")
+				So(atomic.LoadInt32(&unreachableOpens), ShouldEqual, 3)
+			})
+
+			Convey("Check initLogger", func() {
+				defer keepLogger()()
+
+				cfg.Log.Level = "loud"
+				So(initLogger(), ShouldNotBeNil)
+
+				cfg.Log.Level = "debug"
+				cfg.Log.Format = "json"
+				So(initLogger(), ShouldBeNil)
+				So(slog.Default().Enabled(context.Background(), slog.LevelDebug), ShouldBeTrue)
+
+				cfg.Log.Level = ""
+				cfg.Log.Format = ""
+			})
+
 			Convey("Test generateDataSourceName()", func() {
 				cfg.Database.Driver = "test"
 				cfg.Database.Database = "test"
@@ -215,7 +565,7 @@ func TestWorker(t *testing.T) {
 				cfg.Database.Password = "test"
 				cfg.Database.Port = 1234
 				cfg.Database.Host = "localhost"
-				So(generateDataSourceName(), ShouldEqual, "host=localhost port=1234 user=test password=test dbname=test sslmode=disable")
+				So(generateDataSourceName(cfg), ShouldEqual, "host=localhost port=1234 user=test password=test dbname=test sslmode=disable")
 			})
 
 		})
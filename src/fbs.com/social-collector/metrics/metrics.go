@@ -0,0 +1,108 @@
+// Package metrics holds the Prometheus collectors of the collection
+// pipeline.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const namespace = "social_collector"
+
+var (
+	// Registry holds every collector of the collector, plus the Go runtime
+	// and process ones.
+	Registry = prometheus.NewRegistry()
+
+	BatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "worker_batch_users",
+		Help:      "Users fetched from the database per worker batch.",
+		Buckets:   []float64{0, 1, 5, 10, 25, 50, 75, 100},
+	})
+
+	Responses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_responses_total",
+		Help:      "Provider responses by HTTP status, \"error\" when no response was received.",
+	}, []string{"provider", "code"})
+
+	Lookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lookups_total",
+		Help:      "Finished lookups by result: found, not_found, queued, rejected or error.",
+	}, []string{"provider", "result"})
+
+	LookupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "lookup_duration_seconds",
+		Help:      "Time spent in a provider lookup, rate limiting and retries included.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"provider"})
+
+	InsertErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "insert_errors_total",
+		Help:      "Failed writes of social profiles.",
+	})
+
+	RateLimitWaits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_waits_total",
+		Help:      "Requests delayed by the rate limiter.",
+	})
+
+	RateLimitWaitSeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_wait_seconds_total",
+		Help:      "Time requests were delayed by the rate limiter.",
+	})
+
+	LookupCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lookup_cache_total",
+		Help:      "Lookups by canonical email: hit when another user's request was shared, miss when none was, stored when a saved result served a miss.",
+	}, []string{"result"})
+
+	PhotoMirrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "photo_mirrors_total",
+		Help:      "Photo mirrors by result: stored, exists or error.",
+	}, []string{"result"})
+
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Users waiting in the messages channel.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		BatchSize,
+		Responses,
+		Lookups,
+		LookupDuration,
+		InsertErrors,
+		RateLimitWaits,
+		RateLimitWaitSeconds,
+		QueueDepth,
+		PhotoMirrors,
+		LookupCache,
+	)
+}
+
+// Handler serves the collectors of Registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// RateLimited records a request delayed by d.
+func RateLimited(d time.Duration) {
+	RateLimitWaits.Inc()
+	RateLimitWaitSeconds.Add(d.Seconds())
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+
+	Convey("Metrics", t, func() {
+
+		Convey("RateLimited counts the wait", func() {
+			waits := testutil.ToFloat64(RateLimitWaits)
+			seconds := testutil.ToFloat64(RateLimitWaitSeconds)
+
+			RateLimited(1500 * time.Millisecond)
+
+			So(testutil.ToFloat64(RateLimitWaits), ShouldEqual, waits+1)
+			So(testutil.ToFloat64(RateLimitWaitSeconds), ShouldAlmostEqual, seconds+1.5)
+		})
+
+		Convey("Handler exposes the collectors", func() {
+			Lookups.WithLabelValues("test", "found").Inc()
+
+			w := httptest.NewRecorder()
+			Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+			So(w.Code, ShouldEqual, 200)
+			So(w.Body.String(), ShouldContainSubstring, `social_collector_lookups_total{provider="test",result="found"} 1`)
+			So(w.Body.String(), ShouldContainSubstring, "social_collector_worker_batch_users")
+			So(w.Body.String(), ShouldContainSubstring, "go_goroutines")
+		})
+
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fbs.com/social-collector/migrations"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const migrateUsage = "usage: social-collector migrate up|down [steps]|status"
+
+// runMigrate implements the migrate subcommand.
+func runMigrate(w io.Writer, args []string) (err error) {
+
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := migrations.Up(dbMap.Db)
+		for _, m := range done {
+			fmt.Fprintf(w, "applied %s\n", m)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Fprintln(w, "schema is up to date")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errors.New(migrateUsage)
+			}
+		}
+		done, err := migrations.Down(dbMap.Db, steps)
+		for _, m := range done {
+			fmt.Fprintf(w, "reverted %s\n", m)
+		}
+		return err
+
+	case "status":
+		statuses, err := migrations.List(dbMap.Db)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied() {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%s\t%s\n", s.Migration, applied)
+		}
+		return nil
+	}
+
+	return errors.New(migrateUsage)
+}
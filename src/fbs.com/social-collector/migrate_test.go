@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrate(t *testing.T) {
+
+	Convey("Migrate", t, func() {
+
+		cfg.Database.Driver = `testdb`
+		err := initDb()
+		So(err, ShouldBeNil)
+		defer dbMap.Db.Close()
+		defer testdb.Reset()
+
+		testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+			return testResult{1, 1}, nil
+		})
+		testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+			if strings.Contains(query, "to_regclass") {
+				return testdb.RowsFromSlice([]string{"exists"}, [][]driver.Value{{true}}), nil
+			}
+			return testdb.RowsFromSlice([]string{"version", "applied_at"}, [][]driver.Value{{int64(1), time.Now()}}), nil
+		})
+
+		var out bytes.Buffer
+
+		Convey("Missing or unknown action fails", func() {
+			So(runMigrate(&out, nil), ShouldNotBeNil)
+			So(runMigrate(&out, []string{"sideways"}), ShouldNotBeNil)
+			So(runMigrate(&out, []string{"down", "zero"}), ShouldNotBeNil)
+		})
+
+		Convey("Status lists applied and pending migrations", func() {
+			So(runMigrate(&out, []string{"status"}), ShouldBeNil)
+			So(out.String(), ShouldContainSubstring, "0001_create_users\tapplied")
+			So(out.String(), ShouldContainSubstring, "0002_create_lookup_attempts\tpending")
+		})
+
+		Convey("Up prints the applied migrations", func() {
+			So(runMigrate(&out, []string{"up"}), ShouldBeNil)
+			So(out.String(), ShouldContainSubstring, "applied 0002_create_lookup_attempts")
+			So(out.String(), ShouldNotContainSubstring, "applied 0001_create_users")
+		})
+
+		Convey("Down reverts one migration by default", func() {
+			So(runMigrate(&out, []string{"down"}), ShouldBeNil)
+			So(out.String(), ShouldEqual, "reverted 0001_create_users\n")
+		})
+
+	})
+}
@@ -0,0 +1,245 @@
+// Package migrations keeps the schema of the collector tables in step with
+// the binary. Migrations are SQL files embedded from the sql directory and
+// named <version>_<name>.up.sql / <version>_<name>.down.sql.
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+const (
+	createTableQuery = `create schema if not exists social;
+create table if not exists social.schema_migrations (
+    version    integer     primary key,
+    name       text        not null,
+    applied_at timestamptz not null default now()
+)`
+	existsQuery  = `select to_regclass('social.schema_migrations') is not null`
+	appliedQuery = `select version, applied_at from social.schema_migrations order by version`
+	insertQuery  = `insert into social.schema_migrations (version, name) values ($1, $2)`
+	deleteQuery  = `delete from social.schema_migrations where version = $1`
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Status is a migration together with the time it was applied, zero when it
+// is pending.
+type Status struct {
+	Migration
+	AppliedAt time.Time
+}
+
+func (s Status) Applied() bool {
+	return !s.AppliedAt.IsZero()
+}
+
+// All returns the embedded migrations ordered by version.
+func All() ([]Migration, error) {
+
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, errors.New("All:unexpected file " + name)
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(name, "."+direction+".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, errors.New("All:bad migration name " + name)
+		}
+
+		data, err := files.ReadFile(path.Join("sql", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	all := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("All:migration %d needs both up and down files", m.Version)
+		}
+		all = append(all, *m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Version < all[j].Version })
+
+	return all, nil
+}
+
+// List returns every migration with its applied time. It only reads, so
+// that it runs with a read-only role: without social.schema_migrations
+// nothing is applied.
+func List(db *sql.DB) (statuses []Status, err error) {
+
+	all, err := All()
+	if err != nil {
+		return
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return
+	}
+
+	for _, m := range all {
+		statuses = append(statuses, Status{Migration: m, AppliedAt: applied[m.Version]})
+	}
+	return
+}
+
+// Up applies every pending migration in order, each in its own transaction.
+func Up(db *sql.DB) (done []Migration, err error) {
+
+	if _, err = db.Exec(createTableQuery); err != nil {
+		return
+	}
+
+	statuses, err := List(db)
+	if err != nil {
+		return
+	}
+
+	for _, s := range statuses {
+		if s.Applied() {
+			continue
+		}
+		if err = apply(db, s.Migration.Up, insertQuery, s.Version, s.Name); err != nil {
+			err = fmt.Errorf("Up:migration %s: %s", s.Migration, err)
+			return
+		}
+		done = append(done, s.Migration)
+	}
+	return
+}
+
+// Down reverts the last steps applied migrations, newest first.
+func Down(db *sql.DB, steps int) (done []Migration, err error) {
+
+	statuses, err := List(db)
+	if err != nil {
+		return
+	}
+
+	for i := len(statuses) - 1; i >= 0 && len(done) < steps; i-- {
+		s := statuses[i]
+		if !s.Applied() {
+			continue
+		}
+		if err = apply(db, s.Migration.Down, deleteQuery, s.Version); err != nil {
+			err = fmt.Errorf("Down:migration %s: %s", s.Migration, err)
+			return
+		}
+		done = append(done, s.Migration)
+	}
+	return
+}
+
+// Check returns an error unless every embedded migration has been applied.
+func Check(db *sql.DB) error {
+
+	statuses, err := List(db)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, s := range statuses {
+		if !s.Applied() {
+			pending = append(pending, s.String())
+		}
+	}
+	if len(pending) > 0 {
+		return errors.New("Check:schema is out of date, pending migrations: " + strings.Join(pending, ", ") + "; run migrate up")
+	}
+	return nil
+}
+
+func appliedVersions(db *sql.DB) (applied map[int]time.Time, err error) {
+
+	applied = map[int]time.Time{}
+
+	var exists bool
+	if err = db.QueryRow(existsQuery).Scan(&exists); err != nil || !exists {
+		return
+	}
+
+	rows, err := db.Query(appliedQuery)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return
+		}
+		applied[version] = appliedAt
+	}
+	err = rows.Err()
+	return
+}
+
+func apply(db *sql.DB, script string, record string, args ...interface{}) (err error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+
+	if _, err = tx.Exec(script); err != nil {
+		tx.Rollback()
+		return
+	}
+	if _, err = tx.Exec(record, args...); err != nil {
+		tx.Rollback()
+		return
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,126 @@
+package migrations
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testResult struct{}
+
+func (r testResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (r testResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
+func stubApplied(versions ...int) {
+	testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+		if strings.Contains(query, "to_regclass") {
+			return testdb.RowsFromSlice([]string{"exists"}, [][]driver.Value{{true}}), nil
+		}
+		var data [][]driver.Value
+		for _, v := range versions {
+			data = append(data, []driver.Value{int64(v), time.Now()})
+		}
+		return testdb.RowsFromSlice([]string{"version", "applied_at"}, data), nil
+	})
+}
+
+func TestMigrations(t *testing.T) {
+
+	Convey("Migrations", t, func() {
+
+		db, err := sql.Open("testdb", "")
+		So(err, ShouldBeNil)
+		defer db.Close()
+		defer testdb.Reset()
+
+		var executed []string
+		testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+			executed = append(executed, query)
+			return testResult{}, nil
+		})
+
+		all, err := All()
+		So(err, ShouldBeNil)
+
+		Convey("Embedded migrations are ordered and complete", func() {
+			So(len(all), ShouldBeGreaterThanOrEqualTo, 3)
+			for i, m := range all {
+				So(m.Version, ShouldEqual, i+1)
+				So(m.Name, ShouldNotBeEmpty)
+				So(m.Up, ShouldNotBeEmpty)
+				So(m.Down, ShouldNotBeEmpty)
+			}
+			So(all[0].Up, ShouldContainSubstring, "social.users")
+		})
+
+		Convey("Up applies only pending migrations", func() {
+			stubApplied(1)
+
+			done, err := Up(db)
+
+			So(err, ShouldBeNil)
+			So(done, ShouldHaveLength, len(all)-1)
+			So(done[0].Version, ShouldEqual, 2)
+			So(executed[len(executed)-1], ShouldContainSubstring, "insert into social.schema_migrations")
+		})
+
+		Convey("Down reverts the newest migrations", func() {
+			stubApplied(1, 2, 3)
+
+			done, err := Down(db, 2)
+
+			So(err, ShouldBeNil)
+			So(done, ShouldHaveLength, 2)
+			So(done[0].Version, ShouldEqual, 3)
+			So(done[1].Version, ShouldEqual, 2)
+			So(strings.Join(executed, "\n"), ShouldContainSubstring, "delete from social.schema_migrations")
+		})
+
+		Convey("Check fails on pending migrations", func() {
+			stubApplied(1)
+
+			err := Check(db)
+
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "0002_create_lookup_attempts")
+		})
+
+		Convey("Reading the status without the table changes nothing", func() {
+			testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+				So(query, ShouldContainSubstring, "to_regclass")
+				return testdb.RowsFromSlice([]string{"exists"}, [][]driver.Value{{false}}), nil
+			})
+
+			statuses, err := List(db)
+			So(err, ShouldBeNil)
+			So(statuses, ShouldHaveLength, len(all))
+			So(statuses[0].Applied(), ShouldBeFalse)
+			So(Check(db), ShouldNotBeNil)
+			So(executed, ShouldBeEmpty)
+
+			_, err = Up(db)
+			So(err, ShouldBeNil)
+			So(executed[0], ShouldContainSubstring, "create table if not exists social.schema_migrations")
+		})
+
+		Convey("Check passes when everything is applied", func() {
+			var versions []int
+			for _, m := range all {
+				versions = append(versions, m.Version)
+			}
+			stubApplied(versions...)
+
+			So(Check(db), ShouldBeNil)
+		})
+
+	})
+}
@@ -0,0 +1,8 @@
+create schema if not exists social;
+
+create table if not exists social.users (
+    user_id      integer primary key,
+    facebook_url text    not null default '',
+    twitter_url  text    not null default '',
+    photo_url    text    not null default ''
+);
@@ -0,0 +1,7 @@
+create table social.lookup_attempts (
+    user_id      integer     not null,
+    provider     text        not null,
+    status       text        not null,
+    attempted_at timestamptz not null,
+    primary key (user_id, provider)
+);
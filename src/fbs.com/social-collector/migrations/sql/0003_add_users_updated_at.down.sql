@@ -0,0 +1 @@
+alter table social.users drop column updated_at;
@@ -0,0 +1 @@
+alter table social.users add column updated_at timestamptz not null default now();
@@ -0,0 +1,7 @@
+create table social.checkpoints (
+    source     text        not null,
+    provider   text        not null,
+    max_id     integer     not null,
+    updated_at timestamptz not null default now(),
+    primary key (source, provider)
+);
@@ -0,0 +1 @@
+drop function social.notify_user();
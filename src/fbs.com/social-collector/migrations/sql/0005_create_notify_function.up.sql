@@ -0,0 +1,9 @@
+-- Trigger function installed on the source table by "notify install": it
+-- notifies the channel given as first argument with the value of the id
+-- column given as second argument.
+create or replace function social.notify_user() returns trigger language plpgsql as $$
+begin
+    perform pg_notify(tg_argv[0], to_jsonb(new) ->> tg_argv[1]);
+    return new;
+end
+$$;
@@ -0,0 +1,12 @@
+create table social.profiles (
+    user_id    integer     not null,
+    network    text        not null,
+    username   text        not null default '',
+    profile_id text        not null default '',
+    url        text        not null default '',
+    bio        text        not null default '',
+    followers  integer     not null default 0,
+    following  integer     not null default 0,
+    updated_at timestamptz not null default now(),
+    primary key (user_id, network, url)
+);
@@ -0,0 +1 @@
+alter table social.users drop column photo_key;
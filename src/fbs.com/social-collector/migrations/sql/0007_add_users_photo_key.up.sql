@@ -0,0 +1 @@
+alter table social.users add column photo_key text not null default '';
@@ -0,0 +1,7 @@
+create table social.mailbox_results (
+    provider     text        not null,
+    email        text        not null,
+    social       jsonb       not null,
+    looked_up_at timestamptz not null,
+    primary key (provider, email)
+);
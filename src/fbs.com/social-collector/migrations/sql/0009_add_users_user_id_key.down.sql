@@ -0,0 +1 @@
+drop index if exists social.users_user_id_key;
@@ -0,0 +1,3 @@
+-- social.users may predate 0001, which then adopted it whatever its keys: the
+-- upserts on user_id need a unique index on it.
+create unique index if not exists users_user_id_key on social.users (user_id);
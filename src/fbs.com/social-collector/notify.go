@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fbs.com/social-collector/metrics"
+	"fbs.com/social-collector/types"
+	"fmt"
+	"github.com/lib/pq"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultNotifyChannel = "social_collector_users"
+	defaultNotifySweep   = 10 * time.Minute
+	notifyTrigger        = "social_collector_notify"
+	notifyBeat           = 30 * time.Second
+	notifyUsage          = "usage: social-collector notify install|uninstall"
+)
+
+func notifyChannel() string {
+	if channel := currentCfg().Notify.Channel; channel != "" {
+		return channel
+	}
+	return defaultNotifyChannel
+}
+
+// notifySweep returns the interval between sweeps of the whole source when
+// notifications are enabled.
+func notifySweep() time.Duration {
+	if sweep := currentCfg().Notify.Sweep; sweep > 0 {
+		return sweep
+	}
+	return defaultNotifySweep
+}
+
+// notifier forwards the user ids the source trigger notifies on the
+// configured channel.
+type notifier struct {
+	ids    chan int
+	missed chan struct{}
+	close  func() error
+}
+
+// listenNotify starts listening for new users when notify.enabled is set,
+// and returns nil otherwise. The listener reconnects on its own; missed is
+// signalled after a reconnection, as notifications may have been lost.
+func listenNotify(ctx context.Context) *notifier {
+
+	if !currentCfg().Notify.Enabled {
+		return nil
+	}
+
+	n := &notifier{ids: make(chan int, 64), missed: make(chan struct{}, 1)}
+	channel := notifyChannel()
+
+	listener := pq.NewListener(generateDataSourceName(currentCfg()), time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			slog.Warn("notify listener disconnected", "error", err)
+		case pq.ListenerEventConnectionAttemptFailed:
+			slog.Warn("notify listener cannot connect", "error", err)
+		case pq.ListenerEventReconnected:
+			slog.Info("notify listener reconnected")
+		}
+	})
+	n.close = listener.Close
+
+	go func() {
+		if err := listener.Listen(channel); err != nil {
+			slog.Error("listen for new users", "channel", channel, "error", err)
+			return
+		}
+		slog.Info("listening for new users", "channel", channel)
+
+		for notification := range listener.Notify {
+			if notification == nil {
+				n.miss()
+				continue
+			}
+			id, err := strconv.Atoi(notification.Extra)
+			if err != nil {
+				slog.Warn("ignoring notification", "channel", channel, "payload", notification.Extra)
+				continue
+			}
+			select {
+			case n.ids <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return n
+}
+
+func (n *notifier) miss() {
+	select {
+	case n.missed <- struct{}{}:
+	default:
+	}
+}
+
+func (n *notifier) Close() error {
+	return n.close()
+}
+
+// Wait sends the notified users to messages until the next sweep is due:
+// once interval has passed, or right after a reconnection.
+func (n *notifier) Wait(ctx context.Context, messages *chan types.User, provider string, interval time.Duration) {
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	// The worker is idle, not stuck: keep the health check informed.
+	beat := time.NewTicker(notifyBeat)
+	defer beat.Stop()
+
+	for {
+		pipeline.Beat()
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-beat.C:
+		case <-n.missed:
+			return
+		case id := <-n.ids:
+			sendNotified(ctx, messages, id, provider)
+		}
+	}
+}
+
+// Drain sends the users notified so far to messages, without waiting.
+func (n *notifier) Drain(ctx context.Context, messages *chan types.User, provider string) {
+	for {
+		select {
+		case id := <-n.ids:
+			sendNotified(ctx, messages, id, provider)
+		default:
+			return
+		}
+	}
+}
+
+// sendNotified sends user id to messages unless it needs no lookup.
+func sendNotified(ctx context.Context, messages *chan types.User, id int, provider string) {
+
+	users, err := selectPending(provider, "u.id = :id", map[string]interface{}{"id": id})
+	if err != nil {
+		slog.Error("select notified user", "user_id", id, "error", err)
+		return
+	}
+
+	for _, user := range users {
+		select {
+		case *messages <- user:
+			metrics.QueueDepth.Set(float64(len(*messages)))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notifyTriggerQueries returns the statements installing the trigger that
+// notifies new users of the source, and those removing it.
+func notifyTriggerQueries(s types.Source, channel string) (install string, uninstall string) {
+
+	table := quoteTable(s.Table)
+	email := pq.QuoteIdentifier(s.Email)
+
+	uninstall = `drop trigger if exists ` + notifyTrigger + ` on ` + table
+	install = uninstall + `;
+create trigger ` + notifyTrigger + ` after insert or update of ` + email + ` on ` + table + `
+for each row when (new.` + email + ` is not null)
+execute procedure social.notify_user(` + pq.QuoteLiteral(channel) + `, ` + pq.QuoteLiteral(s.Id) + `)`
+	return
+}
+
+// runNotify implements the notify subcommand, which installs or removes the
+// trigger on the source table.
+func runNotify(w io.Writer, args []string) (err error) {
+
+	if len(args) != 1 {
+		return errors.New(notifyUsage)
+	}
+
+	s := source()
+	install, uninstall := notifyTriggerQueries(s, notifyChannel())
+
+	switch args[0] {
+	case "install":
+		if _, err = dbMap.Db.Exec(install); err == nil {
+			fmt.Fprintf(w, "installed trigger %s on %s, notifying %s\n", notifyTrigger, s.Table, notifyChannel())
+		}
+	case "uninstall":
+		if _, err = dbMap.Db.Exec(uninstall); err == nil {
+			fmt.Fprintf(w, "removed trigger %s from %s\n", notifyTrigger, s.Table)
+		}
+	default:
+		err = errors.New(notifyUsage)
+	}
+	return
+}
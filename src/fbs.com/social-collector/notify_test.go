@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"fbs.com/social-collector/types"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+
+	Convey("Notify", t, func() {
+
+		defer func(saved types.Config) { cfg = saved }(cfg)
+
+		cfg.Database.Driver = `testdb`
+		So(initDb(), ShouldBeNil)
+		defer dbMap.Db.Close()
+		defer testdb.Reset()
+
+		var selected []driver.Value
+		testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+			selected = args
+			return testdb.RowsFromCSVString([]string{"id", "email"}, "5,new@test.ru"), nil
+		})
+
+		messages := make(chan types.User, 2)
+		n := &notifier{ids: make(chan int, 2), missed: make(chan struct{}, 1)}
+
+		Convey("Is off unless enabled", func() {
+			cfg.Notify.Enabled = false
+			So(listenNotify(context.Background()), ShouldBeNil)
+		})
+
+		Convey("Wait sends notified users until the sweep is due", func() {
+			n.ids <- 5
+
+			started := time.Now()
+			n.Wait(context.Background(), &messages, "fullcontact", 50*time.Millisecond)
+
+			So(time.Since(started), ShouldBeGreaterThanOrEqualTo, 50*time.Millisecond)
+			So(<-messages, ShouldResemble, types.User{Id: 5, Email: "new@test.ru"})
+			So(selected, ShouldContain, int64(5))
+		})
+
+		Convey("Wait returns right after a reconnection", func() {
+			n.miss()
+			n.miss()
+
+			started := time.Now()
+			n.Wait(context.Background(), &messages, "fullcontact", time.Hour)
+
+			So(time.Since(started), ShouldBeLessThan, time.Second)
+			So(messages, ShouldBeEmpty)
+		})
+
+		Convey("Drain sends the users notified so far", func() {
+			n.ids <- 5
+
+			n.Drain(context.Background(), &messages, "fullcontact")
+
+			So(messages, ShouldHaveLength, 1)
+			So(n.ids, ShouldBeEmpty)
+		})
+
+		Convey("Trigger notifies the channel with the source id", func() {
+			install, uninstall := notifyTriggerQueries(types.Source{Table: "crm.contacts", Id: "contact_id", Email: "mail"}, "new_contacts")
+
+			So(uninstall, ShouldEqual, `drop trigger if exists social_collector_notify on "crm"."contacts"`)
+			So(install, ShouldEqual, `drop trigger if exists social_collector_notify on "crm"."contacts";
+create trigger social_collector_notify after insert or update of "mail" on "crm"."contacts"
+for each row when (new."mail" is not null)
+execute procedure social.notify_user('new_contacts', 'contact_id')`)
+		})
+
+		Convey("Subcommand installs and removes the trigger", func() {
+			var executed []string
+			testdb.SetExecFunc(func(query string) (driver.Result, error) {
+				executed = append(executed, query)
+				return testResult{0, 0}, nil
+			})
+			var out bytes.Buffer
+
+			So(runNotify(&out, []string{"install"}), ShouldBeNil)
+			So(runNotify(&out, []string{"uninstall"}), ShouldBeNil)
+			So(executed, ShouldHaveLength, 2)
+			So(executed[0], ShouldContainSubstring, "create trigger")
+			So(out.String(), ShouldEqual, "installed trigger social_collector_notify on personal_area.user, notifying social_collector_users\nremoved trigger social_collector_notify from personal_area.user\n")
+
+			So(runNotify(&out, nil), ShouldNotBeNil)
+			So(runNotify(&out, []string{"enable"}), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fbs.com/social-collector/blobs"
+	"fbs.com/social-collector/metrics"
+	"fbs.com/social-collector/types"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Photo defaults, used when the photos section leaves them out.
+const (
+	defaultPhotoMaxSize = 5 << 20
+	defaultPhotoTimeout = 10 * time.Second
+)
+
+// maxPhotoPixels bounds the decoded size of a photo, which a small file
+// may still claim to be huge.
+const maxPhotoPixels = 25_000_000
+
+// thumbnailSizes are the bounding squares of the thumbnails stored with
+// every mirrored photo.
+var thumbnailSizes = []int{64, 128, 256}
+
+// photoTypes maps the accepted photo content types to key extensions.
+var photoTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// mirror stores a copy of the photo of social when photos are mirrored,
+// and sets its key. A failed mirror is logged and leaves the key empty:
+// the lookup result stands with the original url only.
+func mirror(ctx context.Context, social *types.Social) {
+
+	c := currentCfg()
+	if !c.Photos.Enabled || dryRun || social.PhotoUrl == "" {
+		return
+	}
+
+	key, err := mirrorPhoto(ctx, c, social.PhotoUrl)
+	if err != nil {
+		metrics.PhotoMirrors.WithLabelValues("error").Inc()
+		slog.Warn("mirror photo", "user_id", social.UserId, "url", social.PhotoUrl, "error", err)
+		return
+	}
+	social.PhotoKey = key
+}
+
+// mirrorPhoto downloads the photo at url and stores it, with its
+// thumbnails, under the hash of its content. Photos of an unaccepted type,
+// or larger than the configured size, are refused.
+func mirrorPhoto(ctx context.Context, c types.Config, url string) (key string, err error) {
+
+	store, err := blobs.New(c)
+	if err != nil {
+		return
+	}
+
+	data, ext, err := fetchPhoto(ctx, c.Photos, url)
+	if err != nil {
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	key = hex.EncodeToString(sum[:]) + "." + ext
+
+	exists, err := store.Has(key)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		metrics.PhotoMirrors.WithLabelValues("exists").Inc()
+		return key, nil
+	}
+
+	thumbnails, err := thumbnails(data, ext)
+	if err != nil {
+		return "", err
+	}
+	// The photo is stored last, so that its key implies its thumbnails.
+	for size, thumbnail := range thumbnails {
+		if err = store.Put(thumbnailKey(key, size), thumbnail); err != nil {
+			return "", err
+		}
+	}
+	if err = store.Put(key, data); err != nil {
+		return "", err
+	}
+
+	metrics.PhotoMirrors.WithLabelValues("stored").Inc()
+	return key, nil
+}
+
+// fetchPhoto downloads the photo at url and returns it with the key
+// extension of its type. The type must be accepted both by the
+// Content-Type header and by the content itself.
+func fetchPhoto(ctx context.Context, c types.Photos, url string) (data []byte, ext string, err error) {
+
+	maxSize, timeout := c.MaxSize, c.Timeout
+	if maxSize <= 0 {
+		maxSize = defaultPhotoMaxSize
+	}
+	if timeout <= 0 {
+		timeout = defaultPhotoTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, "", errors.New("fetchPhoto:response status:" + strconv.Itoa(res.StatusCode))
+	}
+
+	declared, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if _, ok := photoTypes[declared]; !ok {
+		return nil, "", errors.New("fetchPhoto:unaccepted content type " + res.Header.Get("Content-Type"))
+	}
+	if res.ContentLength > int64(maxSize) {
+		return nil, "", errors.New("fetchPhoto:photo larger than " + strconv.Itoa(maxSize) + " bytes")
+	}
+
+	data, err = io.ReadAll(io.LimitReader(res.Body, int64(maxSize)+1))
+	if err != nil {
+		return
+	}
+	if len(data) > maxSize {
+		return nil, "", errors.New("fetchPhoto:photo larger than " + strconv.Itoa(maxSize) + " bytes")
+	}
+
+	sniffed := http.DetectContentType(data)
+	if sniffed != declared {
+		return nil, "", errors.New("fetchPhoto:content is " + sniffed + ", not " + declared)
+	}
+	return data, photoTypes[declared], nil
+}
+
+// thumbnailKey returns the key of the thumbnail of the photo at key.
+func thumbnailKey(key string, size int) string {
+	name, ext, _ := strings.Cut(key, ".")
+	if ext != "jpg" {
+		ext = "png"
+	}
+	return name + "_" + strconv.Itoa(size) + "." + ext
+}
+
+// thumbnails decodes a photo and encodes it scaled down to each of the
+// thumbnail sizes: JPEG for JPEG photos, PNG otherwise to keep
+// transparency.
+func thumbnails(data []byte, ext string) (encoded map[int][]byte, err error) {
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("thumbnails:" + err.Error())
+	}
+	if config.Width*config.Height > maxPhotoPixels {
+		return nil, errors.New("thumbnails:photo of " + strconv.Itoa(config.Width) + "x" + strconv.Itoa(config.Height) + " pixels is too large")
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("thumbnails:" + err.Error())
+	}
+
+	encoded = map[int][]byte{}
+	for _, size := range thumbnailSizes {
+		var buf bytes.Buffer
+		thumbnail := scaleDown(src, size)
+		if ext == "jpg" {
+			err = jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, thumbnail)
+		}
+		if err != nil {
+			return nil, err
+		}
+		encoded[size] = buf.Bytes()
+	}
+	return
+}
+
+// scaleDown fits src in a square of size pixels, keeping its aspect ratio,
+// by averaging the source pixels covered by every target pixel. Images
+// already fitting are copied as they are.
+func scaleDown(src image.Image, size int) *image.RGBA {
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	switch {
+	case w <= size && h <= size:
+	case w >= h:
+		w, h = size, max(1, h*size/w)
+	default:
+		w, h = max(1, w*size/h), size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/w)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
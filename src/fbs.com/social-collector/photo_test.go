@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fbs.com/social-collector/blobs"
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testPhoto encodes a width x height image as PNG or JPEG.
+func testPhoto(width int, height int, format string) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 200, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if format == "jpeg" {
+		jpeg.Encode(&buf, img, nil)
+	} else {
+		png.Encode(&buf, img)
+	}
+	return buf.Bytes()
+}
+
+func TestPhoto(t *testing.T) {
+
+	Convey("Photo mirroring", t, func() {
+
+		defer func(saved types.Config) { cfg = saved }(cfg)
+
+		dir, err := ioutil.TempDir("", "photos")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		cfg.Photos = types.Photos{Enabled: true}
+		cfg.Photos.Store.Path = dir
+
+		photo := testPhoto(400, 200, "png")
+		contentType := "image/png"
+		var requests int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Header().Set("Content-Type", contentType)
+			w.Write(photo)
+		}))
+		defer backend.Close()
+
+		Convey("Stores the photo and its thumbnails under its hash", func() {
+			social := types.Social{UserId: 1, PhotoUrl: backend.URL + "/a.png"}
+			mirror(context.Background(), &social)
+
+			So(social.PhotoKey, ShouldEndWith, ".png")
+			So(social.PhotoKey, ShouldHaveLength, 64+len(".png"))
+
+			store, _ := blobs.New(cfg)
+			data, err := store.Get(social.PhotoKey)
+			So(err, ShouldBeNil)
+			So(data, ShouldResemble, photo)
+
+			for _, size := range thumbnailSizes {
+				data, err := store.Get(thumbnailKey(social.PhotoKey, size))
+				So(err, ShouldBeNil)
+				thumbnail, format, err := image.DecodeConfig(bytes.NewReader(data))
+				So(err, ShouldBeNil)
+				So(format, ShouldEqual, "png")
+				So(thumbnail.Width, ShouldEqual, size)
+				So(thumbnail.Height, ShouldEqual, size/2)
+			}
+
+			Convey("The same photo at another url gets the same key", func() {
+				other := types.Social{UserId: 2, PhotoUrl: backend.URL + "/b.png"}
+				mirror(context.Background(), &other)
+				So(other.PhotoKey, ShouldEqual, social.PhotoKey)
+			})
+		})
+
+		Convey("Keeps the url only when mirroring fails", func() {
+			contentType = "text/html"
+			social := types.Social{UserId: 1, PhotoUrl: backend.URL}
+			mirror(context.Background(), &social)
+
+			So(social.PhotoKey, ShouldBeEmpty)
+			So(social.PhotoUrl, ShouldEqual, backend.URL)
+		})
+
+		Convey("Does nothing when disabled or on a dry run", func() {
+			social := types.Social{UserId: 1, PhotoUrl: backend.URL}
+
+			cfg.Photos.Enabled = false
+			mirror(context.Background(), &social)
+
+			cfg.Photos.Enabled = true
+			dryRun = true
+			mirror(context.Background(), &social)
+			dryRun = false
+
+			So(social.PhotoKey, ShouldBeEmpty)
+			So(atomic.LoadInt32(&requests), ShouldEqual, 0)
+		})
+
+		Convey("Fetching", func() {
+
+			Convey("Accepts a photo whose content matches its type", func() {
+				photo = testPhoto(10, 10, "jpeg")
+				contentType = "image/jpeg; charset=binary"
+
+				data, ext, err := fetchPhoto(context.Background(), cfg.Photos, backend.URL)
+				So(err, ShouldBeNil)
+				So(ext, ShouldEqual, "jpg")
+				So(data, ShouldResemble, photo)
+			})
+
+			Convey("Refuses other content types", func() {
+				contentType = "image/svg+xml"
+				_, _, err := fetchPhoto(context.Background(), cfg.Photos, backend.URL)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "unaccepted content type")
+			})
+
+			Convey("Refuses content not matching its type", func() {
+				contentType = "image/jpeg"
+				_, _, err := fetchPhoto(context.Background(), cfg.Photos, backend.URL)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "fetchPhoto:content is image/png, not image/jpeg")
+			})
+
+			Convey("Refuses photos over the size limit", func() {
+				cfg.Photos.MaxSize = len(photo) - 1
+				_, _, err := fetchPhoto(context.Background(), cfg.Photos, backend.URL)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "photo larger than")
+			})
+
+			Convey("Refuses failed responses", func() {
+				_, _, err := fetchPhoto(context.Background(), cfg.Photos, backend.URL+"/missing\x7f")
+				So(err, ShouldNotBeNil)
+
+				missing := testBackend(404, "Not found")
+				defer missing.Close()
+				_, _, err = fetchPhoto(context.Background(), cfg.Photos, missing.URL)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, "fetchPhoto:response status:404")
+			})
+		})
+
+		Convey("Thumbnails", func() {
+
+			Convey("Keeps the aspect ratio and never upscales", func() {
+				src := image.NewRGBA(image.Rect(0, 0, 100, 300))
+				So(scaleDown(src, 64).Bounds().Size(), ShouldResemble, image.Pt(21, 64))
+				So(scaleDown(src, 512).Bounds().Size(), ShouldResemble, image.Pt(100, 300))
+			})
+
+			Convey("Averages the pixels covered", func() {
+				src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+				src.Set(0, 0, color.RGBA{0, 0, 0, 255})
+				src.Set(1, 0, color.RGBA{255, 255, 255, 255})
+				So(scaleDown(src, 1).RGBAAt(0, 0), ShouldResemble, color.RGBA{127, 127, 127, 255})
+			})
+
+			Convey("Are JPEG for JPEG photos and PNG otherwise", func() {
+				So(thumbnailKey("abc.jpg", 64), ShouldEqual, "abc_64.jpg")
+				So(thumbnailKey("abc.gif", 64), ShouldEqual, "abc_64.png")
+
+				encoded, err := thumbnails(testPhoto(300, 300, "jpeg"), "jpg")
+				So(err, ShouldBeNil)
+				So(encoded, ShouldHaveLength, len(thumbnailSizes))
+				_, format, err := image.DecodeConfig(bytes.NewReader(encoded[128]))
+				So(err, ShouldBeNil)
+				So(format, ShouldEqual, "jpeg")
+			})
+
+			Convey("Refuses undecodable photos", func() {
+				_, err := thumbnails([]byte(strings.Repeat("x", 100)), "png")
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
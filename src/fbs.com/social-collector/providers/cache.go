@@ -0,0 +1,139 @@
+package providers
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fbs.com/social-collector/metrics"
+	"fbs.com/social-collector/types"
+	"sync"
+	"time"
+)
+
+type caching struct {
+	Provider
+	canonical func(string) string
+	ttl       time.Duration
+	size      int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	recent  *list.List
+}
+
+// cacheEntry is the lookup of a canonical address, in flight until done is
+// closed.
+type cacheEntry struct {
+	email  string
+	done   chan struct{}
+	social types.Social
+	err    error
+	at     time.Time
+}
+
+// WithCache wraps provider so that users are looked up by the canonical
+// form of their email, and users sharing it share one request: while it is
+// in flight, and for ttl after it succeeded; see WithStore for sharing
+// beyond that. At most size results are kept, the least recently used being
+// dropped first; a size of 0 keeps them all.
+func WithCache(provider Provider, canonical func(string) string, ttl time.Duration, size int) Provider {
+	return &caching{
+		Provider:  provider,
+		canonical: canonical,
+		ttl:       ttl,
+		size:      size,
+		entries:   map[string]*list.Element{},
+		recent:    list.New(),
+	}
+}
+
+func (c *caching) Request(ctx context.Context, user types.User) (social types.Social, err error) {
+
+	email := c.canonical(user.Email)
+
+	for {
+		e, owner := c.entry(email)
+
+		if owner {
+			metrics.LookupCache.WithLabelValues("miss").Inc()
+			social, err = c.Provider.Request(ctx, types.User{Id: user.Id, Email: email})
+			c.finish(e, social, err)
+			return
+		}
+
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return types.Social{}, ctx.Err()
+		}
+
+		// A request cancelled with the context of another user is sent
+		// again for this one.
+		if errors.Is(e.err, context.Canceled) || errors.Is(e.err, context.DeadlineExceeded) {
+			if ctx.Err() == nil {
+				continue
+			}
+		}
+
+		metrics.LookupCache.WithLabelValues("hit").Inc()
+		return forUser(e.social, user.Id), e.err
+	}
+}
+
+// entry returns the entry of email, a new one when there is none in flight
+// or fresh, which the caller then owns and has to finish.
+func (c *caching) entry(email string) (e *cacheEntry, owner bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[email]; ok {
+		e = element.Value.(*cacheEntry)
+		if e.at.IsZero() || time.Since(e.at) < c.ttl {
+			c.recent.MoveToFront(element)
+			return e, false
+		}
+		c.remove(element)
+	}
+
+	e = &cacheEntry{email: email, done: make(chan struct{})}
+	c.entries[email] = c.recent.PushFront(e)
+	for c.size > 0 && c.recent.Len() > c.size {
+		c.remove(c.recent.Back())
+	}
+	return e, true
+}
+
+// finish records the result of e for the users waiting for it. Failed
+// lookups are not kept, the next user sends the request again.
+func (c *caching) finish(e *cacheEntry, social types.Social, err error) {
+
+	c.mu.Lock()
+	e.social, e.err, e.at = social, err, time.Now()
+	if element, ok := c.entries[e.email]; ok && element.Value == e && (err != nil || c.ttl <= 0) {
+		c.remove(element)
+	}
+	c.mu.Unlock()
+
+	close(e.done)
+}
+
+func (c *caching) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*cacheEntry).email)
+	c.recent.Remove(element)
+}
+
+// forUser returns a copy of social, and of its profiles, belonging to the
+// user with the given id.
+func forUser(social types.Social, userId int) types.Social {
+	social.UserId = userId
+	if social.Profiles != nil {
+		profiles := make([]types.Profile, len(social.Profiles))
+		for i, profile := range social.Profiles {
+			profile.UserId = userId
+			profiles[i] = profile
+		}
+		social.Profiles = profiles
+	}
+	return social
+}
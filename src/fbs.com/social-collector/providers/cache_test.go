@@ -0,0 +1,157 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingProvider answers once release is closed, counting its requests.
+type blockingProvider struct {
+	mu      sync.Mutex
+	emails  []string
+	release chan struct{}
+	err     error
+}
+
+func (p *blockingProvider) Name() string {
+	return "blocking"
+}
+
+func (p *blockingProvider) Request(ctx context.Context, user types.User) (types.Social, error) {
+	p.mu.Lock()
+	p.emails = append(p.emails, user.Email)
+	p.mu.Unlock()
+
+	select {
+	case <-p.release:
+	case <-ctx.Done():
+		return types.Social{}, ctx.Err()
+	}
+	if p.err != nil {
+		return types.Social{}, p.err
+	}
+	return types.Social{UserId: user.Id, TwitterUrl: "http://twitter.com/" + user.Email,
+		Profiles: []types.Profile{{UserId: user.Id, Network: "twitter"}}}, nil
+}
+
+func (p *blockingProvider) requests() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.emails)
+}
+
+func TestCache(t *testing.T) {
+
+	Convey("Cache", t, func() {
+
+		backend := &blockingProvider{release: make(chan struct{})}
+		provider := WithCache(backend, strings.ToLower, time.Hour, 0)
+
+		Convey("Sends the canonical email", func() {
+			close(backend.release)
+			social, err := provider.Request(context.Background(), types.User{Id: 1, Email: "John@Test.com"})
+
+			So(err, ShouldBeNil)
+			So(backend.emails, ShouldResemble, []string{"john@test.com"})
+			So(social.UserId, ShouldEqual, 1)
+			So(provider.Name(), ShouldEqual, "blocking")
+		})
+
+		Convey("Users sharing a mailbox share one request", func() {
+			var wg sync.WaitGroup
+			socials := make([]types.Social, 3)
+			for i := range socials {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					socials[i], _ = provider.Request(context.Background(), types.User{Id: i + 1, Email: []string{"a@test.com", "A@test.com", "a@TEST.com"}[i]})
+				}(i)
+			}
+			for backend.requests() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(10 * time.Millisecond)
+			close(backend.release)
+			wg.Wait()
+
+			So(backend.requests(), ShouldEqual, 1)
+			for i, social := range socials {
+				So(social.UserId, ShouldEqual, i+1)
+				So(social.TwitterUrl, ShouldEqual, "http://twitter.com/a@test.com")
+				So(social.Profiles[0].UserId, ShouldEqual, i+1)
+			}
+
+			Convey("And reuse its result afterwards", func() {
+				social, err := provider.Request(context.Background(), types.User{Id: 9, Email: "a@test.com"})
+				So(err, ShouldBeNil)
+				So(social.UserId, ShouldEqual, 9)
+				So(backend.requests(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("Results expire after the ttl", func() {
+			close(backend.release)
+			provider := WithCache(backend, strings.ToLower, 0, 0)
+
+			provider.Request(context.Background(), types.User{Id: 1, Email: "a@test.com"})
+			provider.Request(context.Background(), types.User{Id: 2, Email: "a@test.com"})
+			So(backend.requests(), ShouldEqual, 2)
+		})
+
+		Convey("Failures are not kept", func() {
+			close(backend.release)
+			backend.err = &QueuedError{}
+
+			_, err := provider.Request(context.Background(), types.User{Id: 1, Email: "a@test.com"})
+			So(errors.As(err, new(*QueuedError)), ShouldBeTrue)
+
+			backend.err = nil
+			_, err = provider.Request(context.Background(), types.User{Id: 2, Email: "a@test.com"})
+			So(err, ShouldBeNil)
+			So(backend.requests(), ShouldEqual, 2)
+		})
+
+		Convey("Keeps at most size results, dropping the least recently used", func() {
+			close(backend.release)
+			provider := WithCache(backend, strings.ToLower, time.Hour, 2)
+
+			for _, email := range []string{"a@test.com", "b@test.com", "a@test.com", "c@test.com", "a@test.com", "b@test.com"} {
+				provider.Request(context.Background(), types.User{Id: 1, Email: email})
+			}
+			So(backend.emails, ShouldResemble, []string{"a@test.com", "b@test.com", "c@test.com", "b@test.com"})
+		})
+
+		Convey("A waiting user sends the request again when its owner gave up", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			owner := make(chan error)
+			go func() {
+				_, err := provider.Request(ctx, types.User{Id: 1, Email: "a@test.com"})
+				owner <- err
+			}()
+			for backend.requests() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			waiter := make(chan types.Social)
+			go func() {
+				social, _ := provider.Request(context.Background(), types.User{Id: 2, Email: "a@test.com"})
+				waiter <- social
+			}()
+			time.Sleep(10 * time.Millisecond)
+
+			cancel()
+			So(<-owner, ShouldEqual, context.Canceled)
+			for backend.requests() < 2 {
+				time.Sleep(time.Millisecond)
+			}
+			close(backend.release)
+			So((<-waiter).UserId, ShouldEqual, 2)
+		})
+	})
+}
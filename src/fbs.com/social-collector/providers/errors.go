@@ -0,0 +1,27 @@
+package providers
+
+import (
+	"strconv"
+	"time"
+)
+
+// StatusError is returned when a provider answers with an unexpected HTTP
+// status.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return "Request:response status:" + strconv.Itoa(e.Code)
+}
+
+// QueuedError is returned when the provider accepted the lookup but has no
+// result yet. The request should be sent again once After has passed; a
+// zero After means the provider did not suggest a delay.
+type QueuedError struct {
+	After time.Duration
+}
+
+func (e *QueuedError) Error() string {
+	return "Request:queued, retry after " + e.After.String()
+}
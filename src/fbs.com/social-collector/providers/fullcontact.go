@@ -1,23 +1,48 @@
 package providers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fbs.com/social-collector/metrics"
 	"fbs.com/social-collector/types"
 	"io"
-	_ "log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
 	"time"
 )
 
+func init() {
+	Register("fullcontact", NewFullcontact)
+}
+
 type Fullcontact struct {
-	Url    string
-	ApiKey string
+	Url     string
+	ApiKey  string
+	Limiter *Limiter
+}
+
+// NewFullcontact builds a Fullcontact provider from the fullcontact section
+// of the config. Every provider it builds shares one Limiter, so that a
+// reload does not reset the budget, and transient failures are retried
+// according to the configured policy.
+func NewFullcontact(cfg types.Config) (Provider, error) {
+	provider := Fullcontact{
+		Url:     cfg.Fullcontact.Url,
+		ApiKey:  cfg.Fullcontact.ApiKey,
+		Limiter: sharedLimiter("fullcontact", cfg.Fullcontact.RateLimit, time.Minute),
+	}
+	return WithRetry(provider, cfg.Fullcontact.Retry), nil
+}
+
+func (f Fullcontact) Name() string {
+	return "fullcontact"
 }
 
-func (f Fullcontact) Request(user types.User) (social types.Social, err error) {
+func (f Fullcontact) Request(ctx context.Context, user types.User) (social types.Social, err error) {
 
 	var apiUrl *url.URL
 
@@ -37,47 +62,44 @@ func (f Fullcontact) Request(user types.User) (social types.Social, err error) {
 
 	client := &http.Client{}
 
-	req, err := http.NewRequest("GET", apiUrl.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiUrl.String(), nil)
 	if err != nil {
 		return
 	}
 
+	if err = f.Limiter.Wait(ctx); err != nil {
+		return
+	}
+
 	res, err := client.Do(req)
 	if err != nil {
+		metrics.Responses.WithLabelValues(f.Name(), "error").Inc()
 		return
 	}
 
-	defer res.Body.Close()
+	metrics.Responses.WithLabelValues(f.Name(), strconv.Itoa(res.StatusCode)).Inc()
+	slog.Debug("provider response", "provider", f.Name(), "url", apiUrl.String(), "status", res.StatusCode)
 
-	responseLimit := res.Header.Get("X-Rate-Limit-Limit")
-	responseRemaining := res.Header.Get("X-Rate-Limit-Remaining")
-	responseReset := res.Header.Get("X-Rate-Limit-Reset")
+	f.Limiter.Observe(res)
 
-	limit, err := strconv.ParseInt(responseLimit, 10, 64)
-	if err != nil {
-		limit = 60
-	}
-	remaining, err := strconv.ParseInt(responseRemaining, 10, 64)
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
 	if err != nil {
-		remaining = 60
-	}
-	reset, err := strconv.ParseInt(responseReset, 10, 64)
-	if err != nil {
-		reset = 0
+		return
 	}
+	recordRaw(ctx, res.StatusCode, body)
 
-	time.Sleep(time.Second * time.Duration(60/limit))
-
-	if remaining == 0 {
-		time.Sleep(time.Second * time.Duration(reset+1))
+	if res.StatusCode == 202 {
+		err = &QueuedError{After: queuedAfter(res.Header.Get("Retry-After"))}
+		return
 	}
 
 	if res.StatusCode != 200 {
-		err = errors.New("Request:response status:" + strconv.Itoa(res.StatusCode))
+		err = &StatusError{Code: res.StatusCode}
 		return
 	}
 
-	decoder := json.NewDecoder(res.Body)
+	decoder := json.NewDecoder(bytes.NewReader(body))
 	if err = decoder.Decode(&person); err == io.EOF {
 		return
 	}
@@ -94,6 +116,7 @@ func (f Fullcontact) Request(user types.User) (social types.Social, err error) {
 		if SocialProfile.Type == "facebook" {
 			social.FacebookUrl = SocialProfile.Url
 		}
+		social.Profiles = append(social.Profiles, SocialProfile.Profile(user.Id))
 	}
 
 	for _, Photo := range person.Photos {
@@ -106,6 +129,15 @@ func (f Fullcontact) Request(user types.User) (social types.Social, err error) {
 	return social, nil
 }
 
+// queuedAfter returns the delay suggested with a 202 response.
+func queuedAfter(value string) time.Duration {
+	now := time.Now()
+	if at, ok := retryAfter(value, now); ok {
+		return at.Sub(now)
+	}
+	return 0
+}
+
 type Person struct {
 	Status           int              `json:"status"`
 	RequestId        string           `json:"requestId"`
@@ -156,6 +188,25 @@ type SocialProfile struct {
 	Followers int    `json:"followers,omitempty"`
 }
 
+// Profile converts p into the profile of the user stored in
+// social.profiles, the network being its type.
+func (p SocialProfile) Profile(userId int) types.Profile {
+	network := p.Type
+	if network == "" {
+		network = p.TypeId
+	}
+	return types.Profile{
+		UserId:    userId,
+		Network:   network,
+		Username:  p.Username,
+		ProfileId: p.Id,
+		Url:       p.Url,
+		Bio:       p.Bio,
+		Followers: p.Followers,
+		Following: p.Following,
+	}
+}
+
 type DigitalFootprint struct {
 	Topics []Topic `json:"topics,omitempty"`
 	Scores []Score `json:"scores,omitempty"`
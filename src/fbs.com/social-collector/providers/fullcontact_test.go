@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"fbs.com/social-collector/types"
 	. "github.com/smartystreets/goconvey/convey"
@@ -9,6 +10,7 @@ import (
 	"net/http/httptest"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func testBackend(response_code int, payload string) *httptest.Server {
@@ -36,7 +38,7 @@ func TestRequest(t *testing.T) {
 
 				user := types.User{Email: "test@test.com", Id: 1}
 
-				social, err := provider.Request(user)
+				social, err := provider.Request(context.Background(), user)
 
 				if code == 200 {
 					So(social, ShouldResemble, types.Social{UserId: 1})
@@ -56,7 +58,7 @@ func TestRequest(t *testing.T) {
 
 			user := types.User{Email: "test@test.com", Id: 1}
 
-			social, err := provider.Request(user)
+			social, err := provider.Request(context.Background(), user)
 
 			So(social, ShouldResemble, types.Social{UserId: 0})
 			So(err, ShouldNotBeNil)
@@ -72,7 +74,7 @@ func TestRequest(t *testing.T) {
 
 			user := types.User{Email: "test@test.com", Id: 1}
 
-			social, err := provider.Request(user)
+			social, err := provider.Request(context.Background(), user)
 
 			So(social, ShouldResemble, types.Social{UserId: 0})
 			So(err, ShouldNotBeNil)
@@ -88,7 +90,7 @@ func TestRequest(t *testing.T) {
 
 			user := types.User{Email: "test@test.com", Id: 1}
 
-			social, err := provider.Request(user)
+			social, err := provider.Request(context.Background(), user)
 
 			So(social, ShouldResemble, types.Social{UserId: 0})
 			So(err, ShouldNotBeNil)
@@ -111,13 +113,70 @@ func TestRequest(t *testing.T) {
 
 			user := types.User{Email: "test@test.com", Id: 1}
 
-			social, err := provider.Request(user)
+			social, err := provider.Request(context.Background(), user)
 
 			So(social, ShouldResemble, types.Social{UserId: 1})
 			So(err, ShouldBeNil)
 
 		})
 
+		Convey("Test queued response", func() {
+
+			backend := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Retry-After", "120")
+					w.WriteHeader(202)
+					w.Write([]byte(`{"status":202,"message":"Queued for search."}`))
+				}))
+			defer backend.Close()
+
+			provider := Fullcontact{Url: backend.URL, ApiKey: "1"}
+
+			user := types.User{Email: "test@test.com", Id: 1}
+
+			social, err := provider.Request(context.Background(), user)
+
+			So(social, ShouldResemble, types.Social{UserId: 0})
+			So(err, ShouldHaveSameTypeAs, &QueuedError{})
+			So(err.(*QueuedError).After, ShouldAlmostEqual, 120*time.Second, time.Second)
+			So(Retryable(err), ShouldBeFalse)
+
+		})
+
+		Convey("Test queued response without delay", func() {
+
+			backend := testBackend(202, "{}")
+			defer backend.Close()
+
+			provider := Fullcontact{Url: backend.URL, ApiKey: "1"}
+
+			user := types.User{Email: "test@test.com", Id: 1}
+
+			_, err := provider.Request(context.Background(), user)
+
+			So(err, ShouldResemble, &QueuedError{})
+
+		})
+
+		Convey("Test raw response is recorded", func() {
+
+			backend := testBackend(404, `{"status":404,"message":"Searched within last 24 hours. No results found for this Id."}`)
+			defer backend.Close()
+
+			provider := Fullcontact{Url: backend.URL, ApiKey: "1"}
+
+			user := types.User{Email: "test@test.com", Id: 1}
+
+			ctx, raw := WithRaw(context.Background())
+			_, err := provider.Request(ctx, user)
+
+			status, body := raw.Get()
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, 404)
+			So(string(body), ShouldContainSubstring, "No results found")
+
+		})
+
 		Convey("Test json with social profiles", func() {
 
 			pr := Person{
@@ -135,6 +194,15 @@ func TestRequest(t *testing.T) {
 						Url:      "http://twitter.com/test",
 						Id:       "123456789",
 					},
+					{
+						Type:      "github",
+						TypeName:  "GitHub",
+						Url:       "https://github.com/test",
+						Username:  "test",
+						Bio:       "Gopher",
+						Followers: 12,
+						Following: 3,
+					},
 				},
 			}
 
@@ -146,9 +214,13 @@ func TestRequest(t *testing.T) {
 
 			user := types.User{Email: "test@test.com", Id: 1}
 
-			social, err := provider.Request(user)
+			social, err := provider.Request(context.Background(), user)
 
-			So(social, ShouldResemble, types.Social{UserId: 1, TwitterUrl: "http://twitter.com/test", FacebookUrl: "http://facebook.com/test"})
+			So(social, ShouldResemble, types.Social{UserId: 1, TwitterUrl: "http://twitter.com/test", FacebookUrl: "http://facebook.com/test", Profiles: []types.Profile{
+				{UserId: 1, Network: "facebook", ProfileId: "123456789", Url: "http://facebook.com/test"},
+				{UserId: 1, Network: "twitter", ProfileId: "123456789", Url: "http://twitter.com/test"},
+				{UserId: 1, Network: "github", Username: "test", Url: "https://github.com/test", Bio: "Gopher", Followers: 12, Following: 3},
+			}})
 			So(social.IsValid(), ShouldBeNil)
 			So(err, ShouldBeNil)
 		})
@@ -180,7 +252,7 @@ func TestRequest(t *testing.T) {
 
 			user := types.User{Email: "test@test.com", Id: 1}
 
-			social, err := provider.Request(user)
+			social, err := provider.Request(context.Background(), user)
 
 			So(social, ShouldResemble, types.Social{UserId: 1, PhotoUrl: "https://test2.gif"})
 			So(social.IsValid(), ShouldBeNil)
@@ -196,7 +268,7 @@ func TestRequest(t *testing.T) {
 
 			user := types.User{Email: "test@test.com", Id: 1}
 
-			social, err := provider.Request(user)
+			social, err := provider.Request(context.Background(), user)
 
 			So(social, ShouldResemble, types.Social{UserId: 0})
 			So(err, ShouldNotBeNil)
@@ -214,7 +286,7 @@ func TestRequest(t *testing.T) {
 
 			user := types.User{Email: "test@test.com", Id: 1}
 
-			social, err := provider.Request(user)
+			social, err := provider.Request(context.Background(), user)
 
 			So(social, ShouldResemble, types.Social{UserId: 0})
 			So(err, ShouldNotBeNil)
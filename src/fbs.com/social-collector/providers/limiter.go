@@ -0,0 +1,211 @@
+package providers
+
+import (
+	"context"
+	"fbs.com/social-collector/metrics"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimit is the number of requests per minute allowed until the
+// provider reports its own limit.
+const DefaultRateLimit = 60
+
+// Limiter is a token bucket shared by every goroutine calling a provider.
+// Its budget is learned from the X-Rate-Limit-* and Retry-After headers of
+// the responses. A nil Limiter never waits.
+type Limiter struct {
+	mu         sync.Mutex
+	configured int
+	limit      float64
+	window     time.Duration
+	tokens     float64
+	last       time.Time
+	until      time.Time
+}
+
+// NewLimiter returns a full bucket allowing limit requests per window.
+func NewLimiter(limit int, window time.Duration) *Limiter {
+	if limit < 1 {
+		limit = DefaultRateLimit
+	}
+	if window <= 0 {
+		window = time.Minute
+	}
+	return &Limiter{
+		configured: limit,
+		limit:      float64(limit),
+		window:     window,
+		tokens:     float64(limit),
+		last:       time.Now(),
+	}
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = map[string]*Limiter{}
+)
+
+// sharedLimiter returns the Limiter of the named provider, created on first
+// use. A provider built again, on a config reload, thus keeps the budget
+// learned from the responses and any pause they asked for. A changed limit
+// is applied to the existing bucket.
+func sharedLimiter(name string, limit int, window time.Duration) *Limiter {
+
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+
+	l, ok := limiters[name]
+	if !ok {
+		l = NewLimiter(limit, window)
+		limiters[name] = l
+		return l
+	}
+	l.setLimit(limit)
+	return l
+}
+
+// setLimit replaces the configured limit, unless it is unchanged, which
+// keeps the limit learned from the responses.
+func (l *Limiter) setLimit(limit int) {
+
+	if limit < 1 {
+		limit = DefaultRateLimit
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if limit == l.configured {
+		return
+	}
+	l.refill(time.Now())
+	l.configured = limit
+	l.limit = float64(limit)
+	if l.tokens > l.limit {
+		l.tokens = l.limit
+	}
+}
+
+// Wait takes a token from the bucket, blocking until one is available or
+// ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.refill(now)
+	l.tokens--
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate())
+	}
+	if pause := l.until.Sub(now); pause > delay {
+		delay = pause
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	metrics.RateLimited(delay)
+	slog.Debug("rate limited", "delay", delay)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Observe updates the budget from the rate limit headers of a response.
+// Retry-After is only honoured on 429 and 503, where it throttles the whole
+// provider rather than a single lookup. Observe never blocks, so it is safe
+// to call before the body is consumed.
+func (l *Limiter) Observe(res *http.Response) {
+	if l == nil {
+		return
+	}
+
+	header := res.Header
+
+	limit, limitErr := strconv.ParseInt(header.Get("X-Rate-Limit-Limit"), 10, 64)
+	remaining, remainingErr := strconv.ParseInt(header.Get("X-Rate-Limit-Remaining"), 10, 64)
+	reset, resetErr := strconv.ParseInt(header.Get("X-Rate-Limit-Reset"), 10, 64)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.refill(now)
+
+	if limitErr == nil && limit > 0 {
+		l.limit = float64(limit)
+		if l.tokens > l.limit {
+			l.tokens = l.limit
+		}
+	}
+
+	// Other goroutines may have reserved tokens since the server counted,
+	// so only ever lower the local estimate.
+	if remainingErr == nil && remaining >= 0 && float64(remaining) < l.tokens {
+		l.tokens = float64(remaining)
+	}
+
+	if remainingErr == nil && remaining == 0 && resetErr == nil && reset >= 0 {
+		l.pause(now.Add(time.Second * time.Duration(reset+1)))
+	}
+
+	if res.StatusCode == 429 || res.StatusCode == 503 {
+		if after, ok := retryAfter(header.Get("Retry-After"), now); ok {
+			l.pause(after)
+		}
+	}
+}
+
+func (l *Limiter) rate() float64 {
+	return l.limit / float64(l.window)
+}
+
+func (l *Limiter) refill(now time.Time) {
+	l.tokens += float64(now.Sub(l.last)) * l.rate()
+	if l.tokens > l.limit {
+		l.tokens = l.limit
+	}
+	l.last = now
+}
+
+func (l *Limiter) pause(until time.Time) {
+	if until.After(l.until) {
+		l.until = until
+	}
+}
+
+// retryAfter parses a Retry-After value given either in seconds or as an
+// HTTP date.
+func retryAfter(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds >= 0 {
+		return now.Add(time.Second * time.Duration(seconds)), true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return at, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,134 @@
+package providers
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+
+	Convey("Limiter", t, func() {
+
+		Convey("Nil limiter never waits", func() {
+			var l *Limiter
+
+			start := time.Now()
+			l.Observe(&http.Response{StatusCode: 200, Header: http.Header{"X-Rate-Limit-Remaining": {"0"}, "X-Rate-Limit-Reset": {"10"}}})
+
+			So(l.Wait(context.Background()), ShouldBeNil)
+			So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+		})
+
+		Convey("Full bucket does not delay", func() {
+			l := NewLimiter(3, time.Minute)
+
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				So(l.Wait(context.Background()), ShouldBeNil)
+			}
+
+			So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+		})
+
+		Convey("Empty bucket is shared by concurrent callers", func() {
+			l := NewLimiter(10, time.Second)
+			l.tokens = 0
+
+			var wg sync.WaitGroup
+			start := time.Now()
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					l.Wait(context.Background())
+				}()
+			}
+			wg.Wait()
+
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 250*time.Millisecond)
+		})
+
+		Convey("Zero limit header is ignored", func() {
+			l := NewLimiter(60, time.Minute)
+			l.Observe(&http.Response{StatusCode: 200, Header: http.Header{"X-Rate-Limit-Limit": {"0"}}})
+
+			So(l.limit, ShouldEqual, 60)
+		})
+
+		Convey("Limit header changes the budget", func() {
+			l := NewLimiter(60, time.Minute)
+			l.Observe(&http.Response{StatusCode: 200, Header: http.Header{"X-Rate-Limit-Limit": {"30"}, "X-Rate-Limit-Remaining": {"12"}}})
+
+			So(l.limit, ShouldEqual, 30)
+			So(l.tokens, ShouldAlmostEqual, 12, 0.1)
+		})
+
+		Convey("Exhausted window pauses until reset", func() {
+			l := NewLimiter(60, time.Minute)
+			l.Observe(&http.Response{StatusCode: 200, Header: http.Header{"X-Rate-Limit-Remaining": {"0"}, "X-Rate-Limit-Reset": {"7"}}})
+
+			So(l.until, ShouldHappenAfter, time.Now().Add(7*time.Second))
+		})
+
+		Convey("Retry-After in seconds pauses", func() {
+			l := NewLimiter(60, time.Minute)
+			l.Observe(&http.Response{StatusCode: 429, Header: http.Header{"Retry-After": {"30"}}})
+
+			So(l.until, ShouldHappenAfter, time.Now().Add(29*time.Second))
+		})
+
+		Convey("Retry-After as HTTP date pauses", func() {
+			l := NewLimiter(60, time.Minute)
+			at := time.Now().Add(time.Hour).UTC()
+			l.Observe(&http.Response{StatusCode: 503, Header: http.Header{"Retry-After": {at.Format(http.TimeFormat)}}})
+
+			So(l.until, ShouldHappenAfter, time.Now().Add(59*time.Minute))
+		})
+
+		Convey("Retry-After of a queued lookup does not pause", func() {
+			l := NewLimiter(60, time.Minute)
+			l.Observe(&http.Response{StatusCode: 202, Header: http.Header{"Retry-After": {"30"}}})
+
+			So(l.until.IsZero(), ShouldBeTrue)
+		})
+
+		Convey("Shared limiter keeps its budget and pause when built again", func() {
+			l := sharedLimiter("shared-test", 60, time.Minute)
+			l.Observe(&http.Response{StatusCode: 200, Header: http.Header{"X-Rate-Limit-Limit": {"30"}, "X-Rate-Limit-Remaining": {"2"}}})
+			l.Observe(&http.Response{StatusCode: 429, Header: http.Header{"Retry-After": {"30"}}})
+			until := l.until
+
+			So(sharedLimiter("shared-test", 60, time.Minute), ShouldPointTo, l)
+			So(l.limit, ShouldEqual, 30)
+			So(l.tokens, ShouldBeLessThan, 3)
+			So(l.until, ShouldEqual, until)
+
+			So(sharedLimiter("shared-test", 20, time.Minute), ShouldPointTo, l)
+			So(l.limit, ShouldEqual, 20)
+			So(l.until, ShouldEqual, until)
+
+			So(sharedLimiter("other-test", 20, time.Minute), ShouldNotPointTo, l)
+		})
+
+		Convey("Cancelled context stops waiting and returns the token", func() {
+			l := NewLimiter(60, time.Minute)
+			l.Observe(&http.Response{StatusCode: 429, Header: http.Header{"Retry-After": {"30"}}})
+			tokens := l.tokens
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			err := l.Wait(ctx)
+
+			So(err, ShouldResemble, context.DeadlineExceeded)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(l.tokens, ShouldAlmostEqual, tokens, 0.1)
+		})
+
+	})
+}
@@ -0,0 +1,58 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fbs.com/social-collector/types"
+	"sort"
+)
+
+const DefaultProvider = "fullcontact"
+
+// Provider looks up the social profiles of a single user.
+type Provider interface {
+	Name() string
+	Request(ctx context.Context, user types.User) (types.Social, error)
+}
+
+// Factory builds a Provider from the collector configuration.
+type Factory func(cfg types.Config) (Provider, error)
+
+var registry = map[string]Factory{}
+
+// Register makes a provider available under name. It is meant to be called
+// from the init function of the file implementing the provider.
+func Register(name string, factory Factory) {
+	if factory == nil {
+		panic("providers: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("providers: Register called twice for provider " + name)
+	}
+	registry[name] = factory
+}
+
+// Names returns the sorted list of registered providers.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns the provider selected by cfg.Provider, falling back to
+// DefaultProvider when none is configured.
+func New(cfg types.Config) (Provider, error) {
+	name := cfg.Provider
+	if name == "" {
+		name = DefaultProvider
+	}
+
+	factory, ok := registry[name]
+	if !ok {
+		return nil, errors.New("New:unknown provider " + name)
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,66 @@
+package providers
+
+import (
+	"context"
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type testProvider struct{}
+
+func (p testProvider) Name() string {
+	return "test"
+}
+
+func (p testProvider) Request(ctx context.Context, user types.User) (types.Social, error) {
+	return types.Social{UserId: user.Id}, nil
+}
+
+func TestRegistry(t *testing.T) {
+
+	Convey("Registry", t, func() {
+
+		Convey("Fullcontact is registered", func() {
+			So(Names(), ShouldContain, "fullcontact")
+		})
+
+		Convey("Empty provider falls back to fullcontact", func() {
+			cfg := types.Config{}
+			cfg.Fullcontact.Url = "http://test.com"
+			cfg.Fullcontact.ApiKey = "1"
+
+			provider, err := New(cfg)
+
+			So(err, ShouldBeNil)
+			So(provider.Name(), ShouldEqual, "fullcontact")
+			So(provider.(Fullcontact).Url, ShouldEqual, "http://test.com")
+			So(provider.(Fullcontact).ApiKey, ShouldEqual, "1")
+			So(provider.(Fullcontact).Limiter, ShouldNotBeNil)
+		})
+
+		Convey("Unknown provider returns error", func() {
+			provider, err := New(types.Config{Provider: "unknown"})
+
+			So(provider, ShouldBeNil)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Registered provider is selected by name", func() {
+			Register("test", func(cfg types.Config) (Provider, error) {
+				return testProvider{}, nil
+			})
+			defer delete(registry, "test")
+
+			provider, err := New(types.Config{Provider: "test"})
+
+			So(err, ShouldBeNil)
+			So(provider.Name(), ShouldEqual, "test")
+		})
+
+		Convey("Register panics on duplicate name", func() {
+			So(func() { Register("fullcontact", NewFullcontact) }, ShouldPanic)
+		})
+
+	})
+}
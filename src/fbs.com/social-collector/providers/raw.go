@@ -0,0 +1,41 @@
+package providers
+
+import (
+	"context"
+	"sync"
+)
+
+// Raw holds the last undecoded response a provider received for a request
+// made with a context returned by WithRaw.
+type Raw struct {
+	mu     sync.Mutex
+	status int
+	body   []byte
+}
+
+type rawKey struct{}
+
+// WithRaw returns a context under which providers record their raw
+// response into the returned Raw.
+func WithRaw(ctx context.Context) (context.Context, *Raw) {
+	raw := &Raw{}
+	return context.WithValue(ctx, rawKey{}, raw), raw
+}
+
+// Get returns the recorded status and body.
+func (r *Raw) Get() (int, []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status, r.body
+}
+
+func recordRaw(ctx context.Context, status int, body []byte) {
+	raw, ok := ctx.Value(rawKey{}).(*Raw)
+	if !ok {
+		return
+	}
+	raw.mu.Lock()
+	raw.status = status
+	raw.body = body
+	raw.mu.Unlock()
+}
@@ -0,0 +1,82 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fbs.com/social-collector/types"
+	"log/slog"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Retryable reports whether a failed request may succeed if sent again:
+// rate limiting, server errors and network failures are, anything else the
+// provider rejected is not.
+func Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code == 429 || status.Code >= 500
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+type retrying struct {
+	Provider
+	policy types.Retry
+}
+
+// WithRetry wraps provider so that retryable failures are sent again with
+// exponential backoff, as configured by policy.
+func WithRetry(provider Provider, policy types.Retry) Provider {
+	if policy.Attempts <= 1 {
+		return provider
+	}
+	return retrying{Provider: provider, policy: policy}
+}
+
+func (r retrying) Request(ctx context.Context, user types.User) (social types.Social, err error) {
+
+	if r.policy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.policy.Deadline)
+		defer cancel()
+	}
+
+	delay := r.policy.Delay
+
+	for attempt := 1; ; attempt++ {
+		social, err = r.Provider.Request(ctx, user)
+		if err == nil || attempt >= r.policy.Attempts || !Retryable(err) {
+			return
+		}
+
+		wait := jitter(delay, r.policy.Jitter)
+		slog.Warn("retrying request", "provider", r.Name(), "user_id", user.Id, "attempt", attempt, "delay", wait, "error", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		delay *= 2
+	}
+}
+
+// jitter spreads delay by up to fraction of its length so that goroutines
+// failing together do not retry together.
+func jitter(delay time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || delay <= 0 {
+		return delay
+	}
+	return delay + time.Duration(rand.Float64()*fraction*float64(delay))
+}
@@ -0,0 +1,126 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+
+	Convey("Retry", t, func() {
+
+		policy := types.Retry{Attempts: 3, Delay: time.Millisecond, Jitter: 0.5, Deadline: time.Second}
+		user := types.User{Email: "test@test.com", Id: 1}
+
+		Convey("Retryable errors", func() {
+			So(Retryable(&StatusError{Code: 429}), ShouldBeTrue)
+			So(Retryable(&StatusError{Code: 500}), ShouldBeTrue)
+			So(Retryable(&StatusError{Code: 503}), ShouldBeTrue)
+			So(Retryable(&StatusError{Code: 400}), ShouldBeFalse)
+			So(Retryable(&StatusError{Code: 403}), ShouldBeFalse)
+			So(Retryable(&StatusError{Code: 422}), ShouldBeFalse)
+			So(Retryable(errors.New("Request:not parse")), ShouldBeFalse)
+			So(Retryable(context.Canceled), ShouldBeFalse)
+		})
+
+		Convey("Single attempt policy does not wrap", func() {
+			provider := Fullcontact{Url: "http://test.com"}
+			So(WithRetry(provider, types.Retry{Attempts: 1}), ShouldResemble, provider)
+		})
+
+		for _, code := range []int{429, 500, 503} {
+			Convey("Status "+http.StatusText(code)+" is retried until success", func() {
+				var calls int32
+				backend := httptest.NewServer(http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						if atomic.AddInt32(&calls, 1) < 3 {
+							w.WriteHeader(code)
+							return
+						}
+						w.WriteHeader(200)
+						w.Write([]byte(`{"socialProfiles":[{"type":"facebook", "url":"http://test.com"}]}`))
+					}))
+				defer backend.Close()
+
+				provider := WithRetry(Fullcontact{Url: backend.URL, ApiKey: "1"}, policy)
+
+				social, err := provider.Request(context.Background(), user)
+
+				So(err, ShouldBeNil)
+				So(social, ShouldResemble, types.Social{UserId: 1, FacebookUrl: "http://test.com",
+					Profiles: []types.Profile{{UserId: 1, Network: "facebook", Url: "http://test.com"}}})
+				So(atomic.LoadInt32(&calls), ShouldEqual, 3)
+			})
+		}
+
+		for _, code := range []int{400, 403, 422} {
+			Convey("Status "+http.StatusText(code)+" fails fast", func() {
+				var calls int32
+				backend := httptest.NewServer(http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						atomic.AddInt32(&calls, 1)
+						w.WriteHeader(code)
+					}))
+				defer backend.Close()
+
+				provider := WithRetry(Fullcontact{Url: backend.URL, ApiKey: "1"}, policy)
+
+				_, err := provider.Request(context.Background(), user)
+
+				So(err, ShouldResemble, &StatusError{Code: code})
+				So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+			})
+		}
+
+		Convey("Attempts are bounded", func() {
+			var calls int32
+			backend := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					atomic.AddInt32(&calls, 1)
+					w.WriteHeader(500)
+				}))
+			defer backend.Close()
+
+			provider := WithRetry(Fullcontact{Url: backend.URL, ApiKey: "1"}, policy)
+
+			_, err := provider.Request(context.Background(), user)
+
+			So(err, ShouldResemble, &StatusError{Code: 500})
+			So(atomic.LoadInt32(&calls), ShouldEqual, 3)
+		})
+
+		Convey("Connection errors are retried", func() {
+			backend := testBackend(200, "{}")
+			backend.Close()
+
+			provider := WithRetry(Fullcontact{Url: backend.URL, ApiKey: "1"}, policy)
+
+			_, err := provider.Request(context.Background(), user)
+
+			So(err, ShouldNotBeNil)
+			So(Retryable(err), ShouldBeTrue)
+		})
+
+		Convey("Deadline stops retrying", func() {
+			backend := testBackend(503, "")
+			defer backend.Close()
+
+			slow := types.Retry{Attempts: 10, Delay: time.Second, Deadline: 50 * time.Millisecond}
+			provider := WithRetry(Fullcontact{Url: backend.URL, ApiKey: "1"}, slow)
+
+			start := time.Now()
+			_, err := provider.Request(context.Background(), user)
+
+			So(err, ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+
+	})
+}
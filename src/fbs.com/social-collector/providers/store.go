@@ -0,0 +1,52 @@
+package providers
+
+import (
+	"context"
+	"fbs.com/social-collector/metrics"
+	"fbs.com/social-collector/types"
+	"log/slog"
+	"time"
+)
+
+// Store keeps found results by the email they were looked up with, so that
+// they outlive the process and the in-memory cache.
+type Store interface {
+	Load(provider string, email string) (social types.Social, at time.Time, ok bool, err error)
+	Save(provider string, email string, social types.Social) error
+}
+
+type storing struct {
+	Provider
+	store  Store
+	maxAge time.Duration
+}
+
+// WithStore wraps provider so that found results are saved to store, and
+// reused for every user with the same email for maxAge, 0 keeping them
+// forever. Under WithCache, which passes the canonical email, one request
+// thus serves every user of a mailbox however far apart they are looked
+// up. A failing store is logged and bypassed.
+func WithStore(provider Provider, store Store, maxAge time.Duration) Provider {
+	return &storing{Provider: provider, store: store, maxAge: maxAge}
+}
+
+func (s *storing) Request(ctx context.Context, user types.User) (social types.Social, err error) {
+
+	name := s.Provider.Name()
+
+	stored, at, ok, loadErr := s.store.Load(name, user.Email)
+	if loadErr != nil {
+		slog.Warn("load stored result", "provider", name, "user_id", user.Id, "error", loadErr)
+	} else if ok && (s.maxAge <= 0 || time.Since(at) < s.maxAge) {
+		metrics.LookupCache.WithLabelValues("stored").Inc()
+		return forUser(stored, user.Id), nil
+	}
+
+	social, err = s.Provider.Request(ctx, user)
+	if err == nil && social.HasProfiles() {
+		if saveErr := s.store.Save(name, user.Email, social); saveErr != nil {
+			slog.Warn("save result", "provider", name, "user_id", user.Id, "error", saveErr)
+		}
+	}
+	return
+}
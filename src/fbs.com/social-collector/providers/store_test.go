@@ -0,0 +1,85 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryStore is a Store in a map, failing with err when it is set.
+type memoryStore struct {
+	results map[string]types.Social
+	at      time.Time
+	err     error
+}
+
+func (m *memoryStore) Load(provider string, email string) (social types.Social, at time.Time, ok bool, err error) {
+	social, ok = m.results[provider+":"+email]
+	return social, m.at, ok, m.err
+}
+
+func (m *memoryStore) Save(provider string, email string, social types.Social) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.results[provider+":"+email] = social
+	return nil
+}
+
+func TestStore(t *testing.T) {
+
+	Convey("Store", t, func() {
+
+		backend := &blockingProvider{release: make(chan struct{})}
+		close(backend.release)
+		store := &memoryStore{results: map[string]types.Social{}, at: time.Now()}
+		provider := WithStore(backend, store, time.Hour)
+
+		Convey("Saves found results and serves them to every user of the email", func() {
+			social, err := provider.Request(context.Background(), types.User{Id: 1, Email: "a@test.com"})
+			So(err, ShouldBeNil)
+			So(store.results["blocking:a@test.com"].TwitterUrl, ShouldEqual, "http://twitter.com/a@test.com")
+
+			social, err = provider.Request(context.Background(), types.User{Id: 2, Email: "a@test.com"})
+			So(err, ShouldBeNil)
+			So(social.UserId, ShouldEqual, 2)
+			So(social.Profiles[0].UserId, ShouldEqual, 2)
+			So(backend.requests(), ShouldEqual, 1)
+			So(provider.Name(), ShouldEqual, "blocking")
+		})
+
+		Convey("Survives the in-memory cache", func() {
+			cached := WithCache(provider, strings.ToLower, 0, 0)
+			cached.Request(context.Background(), types.User{Id: 1, Email: "A@test.com"})
+			cached.Request(context.Background(), types.User{Id: 2, Email: "a@TEST.com"})
+			So(backend.requests(), ShouldEqual, 1)
+		})
+
+		Convey("Looks up again once the result is older than the max age", func() {
+			store.results["blocking:a@test.com"] = types.Social{TwitterUrl: "http://twitter.com/old"}
+			store.at = time.Now().Add(-2 * time.Hour)
+
+			social, err := provider.Request(context.Background(), types.User{Id: 1, Email: "a@test.com"})
+			So(err, ShouldBeNil)
+			So(social.TwitterUrl, ShouldEqual, "http://twitter.com/a@test.com")
+			So(backend.requests(), ShouldEqual, 1)
+		})
+
+		Convey("Failures and empty results are not saved", func() {
+			backend.err = errors.New("Request:failed")
+			provider.Request(context.Background(), types.User{Id: 1, Email: "a@test.com"})
+			So(store.results, ShouldBeEmpty)
+		})
+
+		Convey("A failing store is bypassed", func() {
+			store.err = errors.New("store down")
+			social, err := provider.Request(context.Background(), types.User{Id: 1, Email: "a@test.com"})
+			So(err, ShouldBeNil)
+			So(social.TwitterUrl, ShouldEqual, "http://twitter.com/a@test.com")
+		})
+	})
+}
@@ -0,0 +1,40 @@
+package providers
+
+import (
+	"context"
+	"fbs.com/social-collector/types"
+	"sync"
+)
+
+// Swappable is a Provider forwarding to another one that can be replaced at
+// any time, e.g. after the credentials were rotated. Requests already sent
+// finish on the provider they started with.
+type Swappable struct {
+	mu      sync.RWMutex
+	current Provider
+}
+
+func NewSwappable(provider Provider) *Swappable {
+	return &Swappable{current: provider}
+}
+
+// Swap makes provider serve the next requests.
+func (s *Swappable) Swap(provider Provider) {
+	s.mu.Lock()
+	s.current = provider
+	s.mu.Unlock()
+}
+
+func (s *Swappable) get() Provider {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+func (s *Swappable) Name() string {
+	return s.get().Name()
+}
+
+func (s *Swappable) Request(ctx context.Context, user types.User) (types.Social, error) {
+	return s.get().Request(ctx, user)
+}
@@ -0,0 +1,42 @@
+package providers
+
+import (
+	"context"
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type namedProvider string
+
+func (p namedProvider) Name() string {
+	return string(p)
+}
+
+func (p namedProvider) Request(ctx context.Context, user types.User) (types.Social, error) {
+	return types.Social{UserId: user.Id, PhotoUrl: string(p)}, nil
+}
+
+func TestSwappable(t *testing.T) {
+
+	Convey("Swappable", t, func() {
+
+		s := NewSwappable(namedProvider("old"))
+
+		Convey("Forwards to the current provider", func() {
+			social, err := s.Request(context.Background(), types.User{Id: 1})
+			So(err, ShouldBeNil)
+			So(social.PhotoUrl, ShouldEqual, "old")
+			So(s.Name(), ShouldEqual, "old")
+		})
+
+		Convey("Sends requests to the new provider after Swap", func() {
+			s.Swap(namedProvider("new"))
+
+			social, err := s.Request(context.Background(), types.User{Id: 1})
+			So(err, ShouldBeNil)
+			So(social.PhotoUrl, ShouldEqual, "new")
+			So(s.Name(), ShouldEqual, "new")
+		})
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fbs.com/social-collector/logger"
+	"fbs.com/social-collector/providers"
+	"fbs.com/social-collector/types"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// reloader applies a re-read config to a running pipeline: provider
+// credentials and tuning, concurrency, requeue and log settings take effect
+// without dropping the lookups in flight.
+type reloader struct {
+	provider *providers.Swappable
+	pool     *listeners
+	queue    *requeue
+}
+
+// Reload reads and validates the config again and swaps it in. On error the
+// current config stays in effect.
+func (r *reloader) Reload() (err error) {
+
+	next, err := loadCfg()
+	if err != nil {
+		return
+	}
+
+	old := currentCfg()
+	for _, setting := range keepRestartOnly(old, &next) {
+		slog.Warn("config change needs a restart, ignored", "setting", setting)
+	}
+
+	changes := old.Changes(next)
+	if len(changes) == 0 {
+		slog.Info("config reloaded, nothing changed")
+		return
+	}
+
+	// The provider is built again, sharing the rate limiter of the one it
+	// replaces, when any of its settings changed.
+	name := providerName()
+	var provider providers.Provider
+	for _, change := range changes {
+		if strings.HasPrefix(change, name+".") {
+			if provider, err = providers.New(next); err != nil {
+				return
+			}
+			break
+		}
+	}
+
+	l, err := logger.New(os.Stderr, next.Log.Format, next.Log.Level)
+	if err != nil {
+		return
+	}
+
+	cfgMu.Lock()
+	cfg = next
+	cfgMu.Unlock()
+
+	slog.SetDefault(l)
+	if provider != nil {
+		r.provider.Swap(provider)
+	}
+	r.queue.Configure(next.Requeue.Attempts, next.Requeue.Delay)
+	r.pool.Resize(concurrency())
+
+	slog.Info("config reloaded", "changes", changes)
+	return
+}
+
+// keepRestartOnly resets in next the settings a running process cannot
+// change to their old values, and returns their paths.
+func keepRestartOnly(old types.Config, next *types.Config) (kept []string) {
+
+	if next.Provider != old.Provider {
+		next.Provider = old.Provider
+		kept = append(kept, "provider")
+	}
+	if next.Database != old.Database {
+		next.Database = old.Database
+		kept = append(kept, "database")
+	}
+	if next.Http.Listen != old.Http.Listen {
+		next.Http.Listen = old.Http.Listen
+		kept = append(kept, "http.listen")
+	}
+	if next.Api.Listen != old.Api.Listen {
+		next.Api.Listen = old.Api.Listen
+		kept = append(kept, "api.listen")
+	}
+	if next.Source != old.Source {
+		next.Source = old.Source
+		kept = append(kept, "source")
+	}
+	if !reflect.DeepEqual(next.Sinks, old.Sinks) {
+		next.Sinks = old.Sinks
+		kept = append(kept, "sinks")
+	}
+	if !reflect.DeepEqual(next.Emails, old.Emails) {
+		next.Emails = old.Emails
+		kept = append(kept, "emails")
+	}
+	if next.Notify != old.Notify {
+		next.Notify = old.Notify
+		kept = append(kept, "notify")
+	}
+	if next.Reload != old.Reload {
+		next.Reload = old.Reload
+		kept = append(kept, "reload")
+	}
+	return
+}
+
+// watchReloads reloads the config on SIGHUP and, when reload.watch is set,
+// whenever the config file is modified, until ctx is done.
+func watchReloads(ctx context.Context, r *reloader) {
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if watch := currentCfg().Reload.Watch; watch > 0 {
+		ticker := time.NewTicker(watch)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modified := modTime(configUrl)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-tick:
+			m := modTime(configUrl)
+			if m.Equal(modified) {
+				continue
+			}
+			modified = m
+		}
+
+		if err := r.Reload(); err != nil {
+			slog.Error("config reload failed, keeping the current config", "error", err)
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
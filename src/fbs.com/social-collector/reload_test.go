@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fbs.com/social-collector/providers"
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const reloadConfig = `database:
+  driver: postgres
+  database: social
+  username: collector
+  host: %HOST%
+  port: 5432
+concurrency: %CONCURRENCY%
+requeue:
+  attempts: 3
+  delay: 1m
+reload:
+  watch: 10ms
+fullcontact:
+  key: %KEY%
+  url: https://api.fullcontact.com/v2/person.json
+`
+
+func writeReloadConfig(path string, replacements ...string) {
+	data := strings.NewReplacer(replacements...).Replace(reloadConfig)
+	So(ioutil.WriteFile(path, []byte(data), 0600), ShouldBeNil)
+}
+
+func TestReload(t *testing.T) {
+
+	Convey("Reload", t, func() {
+
+		defer keepLogger()()
+		defer func(saved types.Config, savedUrl string) {
+			cfg, configUrl = saved, savedUrl
+		}(cfg, configUrl)
+
+		dir, err := ioutil.TempDir("", "reload")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		configUrl = filepath.Join(dir, "config.yml")
+		writeReloadConfig(configUrl, "%HOST%", "db", "%CONCURRENCY%", "1", "%KEY%", "old")
+		So(initCfg(), ShouldBeNil)
+
+		messages := make(chan types.User)
+		queue := newRequeue(&messages, cfg.Requeue.Attempts, cfg.Requeue.Delay)
+		pool := newListeners(context.Background(), &messages, &countingProvider{}, queue)
+		pool.Resize(1)
+		defer func() {
+			close(messages)
+			pool.Wait()
+		}()
+
+		current, err := providers.New(cfg)
+		So(err, ShouldBeNil)
+		r := &reloader{provider: providers.NewSwappable(current), pool: pool, queue: queue}
+
+		Convey("Swaps credentials and tuning in place", func() {
+			writeReloadConfig(configUrl, "%HOST%", "db", "%CONCURRENCY%", "3", "%KEY%", "new")
+
+			So(r.Reload(), ShouldBeNil)
+			So(currentCfg().Concurrency, ShouldEqual, 3)
+			So(pool.stops, ShouldHaveLength, 3)
+
+			swapped, err := providers.New(cfg)
+			So(err, ShouldBeNil)
+			So(swapped.Name(), ShouldEqual, r.provider.Name())
+			So(currentCfg().Fullcontact.ApiKey, ShouldEqual, "new")
+		})
+
+		Convey("Keeps settings that need a restart", func() {
+			writeReloadConfig(configUrl, "%HOST%", "elsewhere", "%CONCURRENCY%", "2", "%KEY%", "old")
+
+			So(r.Reload(), ShouldBeNil)
+			So(currentCfg().Database.Host, ShouldEqual, "db")
+			So(currentCfg().Concurrency, ShouldEqual, 2)
+		})
+
+		Convey("Keeps the current config when the new one is invalid", func() {
+			writeReloadConfig(configUrl, "%HOST%", "db", "%CONCURRENCY%", "-1", "%KEY%", "")
+
+			err := r.Reload()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "fullcontact.key is empty")
+			So(currentCfg().Concurrency, ShouldEqual, 1)
+			So(pool.stops, ShouldHaveLength, 1)
+		})
+
+		Convey("Watches the config file when reload.watch is set", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				watchReloads(ctx, r)
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			writeReloadConfig(configUrl, "%HOST%", "db", "%CONCURRENCY%", "2", "%KEY%", "old")
+
+			// Keep touching the file: the watcher may not have read its
+			// first modification time yet.
+			for i := 1; currentCfg().Concurrency != 2 && i < 200; i++ {
+				later := time.Now().Add(time.Duration(i) * time.Minute)
+				So(os.Chtimes(configUrl, later, later), ShouldBeNil)
+				time.Sleep(5 * time.Millisecond)
+			}
+			So(currentCfg().Concurrency, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fbs.com/social-collector/types"
+	"sync"
+	"time"
+)
+
+// requeue sends users whose lookup the provider queued back into messages
+// once the suggested delay has passed, at most attempts times per user. It
+// also tracks the users sent by sources until their lookup finishes.
+//
+// Users are keyed by id and email, as users read from files may have no id.
+type requeue struct {
+	mu       sync.Mutex
+	messages *chan types.User
+	attempts int
+	delay    time.Duration
+	pending  map[types.User]int
+	timers   map[types.User]*time.Timer
+	tracked  map[types.User]int
+	idle     chan struct{}
+	dropped  []types.User
+	closed   bool
+	stop     chan struct{}
+	sending  sync.WaitGroup
+}
+
+func newRequeue(messages *chan types.User, attempts int, delay time.Duration) *requeue {
+	return &requeue{
+		messages: messages,
+		attempts: attempts,
+		delay:    delay,
+		pending:  map[types.User]int{},
+		timers:   map[types.User]*time.Timer{},
+		tracked:  map[types.User]int{},
+		stop:     make(chan struct{}),
+	}
+}
+
+// Schedule re-sends user after the given delay, or after the configured one
+// when the provider did not suggest any. It returns false once the user has
+// used up its attempts or the queue is closed.
+func (q *requeue) Schedule(user types.User, after time.Duration) bool {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	attempt := q.pending[user] + 1
+	if q.closed || attempt > q.attempts {
+		delete(q.pending, user)
+		return false
+	}
+	q.pending[user] = attempt
+
+	if after <= 0 {
+		after = q.delay
+	}
+
+	q.timers[user] = time.AfterFunc(after, func() {
+		q.send(user)
+	})
+	return true
+}
+
+func (q *requeue) send(user types.User) {
+
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	delete(q.timers, user)
+	q.sending.Add(1)
+	q.mu.Unlock()
+
+	defer q.sending.Done()
+
+	select {
+	case *q.messages <- user:
+	case <-q.stop:
+		q.mu.Lock()
+		q.dropped = append(q.dropped, user)
+		q.mu.Unlock()
+	}
+}
+
+// Configure changes the attempts and default delay of the users scheduled
+// from now on.
+func (q *requeue) Configure(attempts int, delay time.Duration) {
+	q.mu.Lock()
+	q.attempts = attempts
+	q.delay = delay
+	q.mu.Unlock()
+}
+
+// Track counts user, about to be sent by a source, as outstanding until
+// Done.
+func (q *requeue) Track(user types.User) {
+	q.mu.Lock()
+	if len(q.tracked) == 0 {
+		q.idle = make(chan struct{})
+	}
+	q.tracked[user]++
+	q.mu.Unlock()
+}
+
+// Done forgets the attempts of a user whose lookup has finished.
+func (q *requeue) Done(user types.User) {
+	q.mu.Lock()
+	delete(q.pending, user)
+	q.untrack(user)
+	q.mu.Unlock()
+}
+
+func (q *requeue) untrack(user types.User) {
+	n, ok := q.tracked[user]
+	switch {
+	case !ok:
+	case n > 1:
+		q.tracked[user] = n - 1
+	default:
+		delete(q.tracked, user)
+		if len(q.tracked) == 0 {
+			close(q.idle)
+		}
+	}
+}
+
+// First returns the lowest id among the tracked users, false when none of
+// them has an id.
+func (q *requeue) First() (id int, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for user := range q.tracked {
+		if user.Id > 0 && (!ok || user.Id < id) {
+			id, ok = user.Id, true
+		}
+	}
+	return
+}
+
+// Wait returns once every tracked user is done, or ctx is.
+func (q *requeue) Wait(ctx context.Context) {
+	q.mu.Lock()
+	if len(q.tracked) == 0 {
+		q.mu.Unlock()
+		return
+	}
+	idle := q.idle
+	q.mu.Unlock()
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+	}
+}
+
+// Close stops scheduling and returns the users still waiting for their
+// delay. Once it returns nothing is sent to messages any more, so the
+// channel may be closed.
+func (q *requeue) Close() (dropped []types.User) {
+
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.stop)
+		// A timer that already fired but is still listed has not reached
+		// send yet and will find the queue closed.
+		for user, timer := range q.timers {
+			timer.Stop()
+			q.dropped = append(q.dropped, user)
+		}
+		q.timers = map[types.User]*time.Timer{}
+	}
+	q.mu.Unlock()
+
+	q.sending.Wait()
+
+	q.mu.Lock()
+	dropped, q.dropped = q.dropped, nil
+	for _, user := range dropped {
+		q.untrack(user)
+	}
+	q.mu.Unlock()
+	return
+}
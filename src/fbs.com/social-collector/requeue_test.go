@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fbs.com/social-collector/types"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestRequeue(t *testing.T) {
+
+	Convey("Requeue", t, func() {
+
+		messages := make(chan types.User, 1)
+		user := types.User{Id: 1, Email: "test@test.com"}
+
+		Convey("User is sent back after the suggested delay", func() {
+			queue := newRequeue(&messages, 2, time.Hour)
+
+			So(queue.Schedule(user, 10*time.Millisecond), ShouldBeTrue)
+
+			select {
+			case got := <-messages:
+				So(got, ShouldResemble, user)
+			case <-time.After(time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+		})
+
+		Convey("Configured delay is used when none is suggested", func() {
+			queue := newRequeue(&messages, 2, 10*time.Millisecond)
+
+			So(queue.Schedule(user, 0), ShouldBeTrue)
+
+			select {
+			case got := <-messages:
+				So(got, ShouldResemble, user)
+			case <-time.After(time.Second):
+				So("timeout", ShouldBeEmpty)
+			}
+		})
+
+		Convey("Configure applies to the users scheduled next", func() {
+			queue := newRequeue(&messages, 1, time.Hour)
+			queue.Configure(2, 10*time.Millisecond)
+
+			So(queue.Schedule(user, 0), ShouldBeTrue)
+			So(<-messages, ShouldResemble, user)
+			So(queue.Schedule(user, time.Hour), ShouldBeTrue)
+			So(queue.Schedule(user, time.Hour), ShouldBeFalse)
+		})
+
+		Convey("Attempts are bounded per user", func() {
+			queue := newRequeue(&messages, 2, time.Hour)
+
+			So(queue.Schedule(user, time.Hour), ShouldBeTrue)
+			So(queue.Schedule(user, time.Hour), ShouldBeTrue)
+			So(queue.Schedule(user, time.Hour), ShouldBeFalse)
+			So(queue.pending, ShouldBeEmpty)
+		})
+
+		Convey("Done resets the attempts", func() {
+			queue := newRequeue(&messages, 1, time.Hour)
+
+			So(queue.Schedule(user, time.Hour), ShouldBeTrue)
+			queue.Done(user)
+			So(queue.Schedule(user, time.Hour), ShouldBeTrue)
+		})
+
+		Convey("Wait returns once the tracked users are done", func() {
+			queue := newRequeue(&messages, 1, time.Hour)
+			other := types.User{Email: "other@test.com"}
+
+			queue.Wait(context.Background())
+
+			queue.Track(user)
+			queue.Track(other)
+			queue.Track(other)
+			queue.Done(user)
+			queue.Done(other)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			queue.Wait(ctx)
+			So(ctx.Err(), ShouldNotBeNil)
+
+			go queue.Done(other)
+			queue.Wait(context.Background())
+			So(queue.tracked, ShouldBeEmpty)
+		})
+
+		Convey("First is the lowest tracked id", func() {
+			queue := newRequeue(&messages, 1, time.Hour)
+
+			_, ok := queue.First()
+			So(ok, ShouldBeFalse)
+
+			queue.Track(types.User{Email: "no-id@test.com"})
+			queue.Track(types.User{Id: 9})
+			queue.Track(types.User{Id: 7})
+			first, ok := queue.First()
+			So(ok, ShouldBeTrue)
+			So(first, ShouldEqual, 7)
+
+			queue.Done(types.User{Id: 7})
+			first, _ = queue.First()
+			So(first, ShouldEqual, 9)
+		})
+
+		Convey("Close returns the users still waiting", func() {
+			queue := newRequeue(&messages, 1, time.Hour)
+
+			So(queue.Schedule(user, time.Hour), ShouldBeTrue)
+			So(queue.Close(), ShouldResemble, []types.User{user})
+			So(queue.Schedule(user, time.Millisecond), ShouldBeFalse)
+		})
+
+		Convey("Close unblocks a pending send", func() {
+			full := make(chan types.User)
+			queue := newRequeue(&full, 1, time.Hour)
+
+			So(queue.Schedule(user, time.Millisecond), ShouldBeTrue)
+			time.Sleep(20 * time.Millisecond)
+
+			So(queue.Close(), ShouldResemble, []types.User{user})
+		})
+
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fbs.com/social-collector/metrics"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpMux routes the endpoints of the optional HTTP listener: metrics and
+// health checks, which are safe to expose to scrapers.
+func httpMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz)
+	return mux
+}
+
+// apiMux routes the enrichment API, which triggers paid lookups and serves
+// stored profiles, and so has a listener of its own.
+func apiMux() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/enrich", postEnrich)
+	mux.HandleFunc("/v1/enrich/", getEnrich)
+	return requireToken(mux)
+}
+
+// requireToken rejects the requests without the api.token bearer token,
+// when one is configured. The token is read on every request, so that a
+// reload rotates it.
+func requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := currentCfg().Api.Token; token != "" {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveHttp serves handler on addr until ctx is done.
+func serveHttp(ctx context.Context, addr string, handler http.Handler) {
+
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("listening", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error("listen", "addr", addr, "error", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fbs.com/social-collector/metrics"
+	"fbs.com/social-collector/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+
+	Convey("Server", t, func() {
+
+		Convey("Metrics endpoint is served", func() {
+			w := httptest.NewRecorder()
+			httpMux().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+			So(w.Code, ShouldEqual, 200)
+			So(w.Body.String(), ShouldContainSubstring, "social_collector_queue_depth")
+		})
+
+		Convey("Listen loop records the queue depth", func() {
+			messages := make(chan types.User, 2)
+			messages <- types.User{Id: 1}
+			messages <- types.User{Id: 2}
+			close(messages)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			listenLoop(ctx, &messages, &countingProvider{}, newRequeue(&messages, 0, 0), nil)
+
+			So(testutil.ToFloat64(metrics.QueueDepth), ShouldEqual, 0)
+		})
+
+	})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fbs.com/social-collector/metrics"
+	"fbs.com/social-collector/types"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// result is the outcome of a lookup, as handed to the sinks.
+type result struct {
+	User     types.User
+	Provider string
+	Status   string
+	Social   types.Social
+	Err      error
+	At       time.Time
+}
+
+// Sink stores lookup results. Write is called by every listener, so
+// implementations must be safe for concurrent use.
+type Sink interface {
+	// Name identifies the sink in logs and errors.
+	Name() string
+	Write(r result) error
+	Close() error
+}
+
+// results is where the lookups of the running pipeline are written.
+var results Sink = postgresSink{}
+
+// newSink opens the configured sinks, stdout only on a dry run.
+func newSink() (Sink, error) {
+
+	specs := currentCfg().Sinks
+	if dryRun {
+		specs = []string{"stdout"}
+	} else if len(specs) == 0 {
+		specs = []string{defaultSink}
+	}
+
+	var sinks multiSink
+	for _, spec := range specs {
+		sink, err := openSink(spec)
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return sinks, nil
+}
+
+const defaultSink = "postgres"
+
+// openSink opens a sink from its spec: postgres, stdout, or jsonl:path and
+// csv:path, "-" being stdout.
+func openSink(spec string) (Sink, error) {
+
+	kind, path, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "postgres":
+		return postgresSink{}, nil
+	case "stdout":
+		return newJSONLSink(spec, os.Stdout), nil
+	case "jsonl", "csv":
+		var w io.Writer = os.Stdout
+		if path != "-" {
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, errors.New("openSink:" + err.Error())
+			}
+			w = file
+		}
+		if kind == "csv" {
+			sink, err := newCSVSink(spec, w)
+			if err != nil {
+				closeOutput(w)
+				return nil, errors.New("openSink:" + err.Error())
+			}
+			return sink, nil
+		}
+		return newJSONLSink(spec, w), nil
+	}
+	return nil, errors.New("openSink:unknown sink " + spec)
+}
+
+// postgresSink saves found profiles to social.users and records every
+// attempt, so that the worker skips the user until the cool-down has passed.
+// Users without an id have nothing to be stored under and are skipped.
+type postgresSink struct{}
+
+func (postgresSink) Name() string {
+	return "postgres"
+}
+
+func (postgresSink) Write(r result) (err error) {
+
+	if r.User.Id == 0 {
+		slog.Info("lookup of a user without id not stored", "email", r.User.Email, "status", r.Status)
+		return nil
+	}
+
+	status := r.Status
+	if status == types.LookupFound {
+		social := r.Social
+		err = saveSocial(&social)
+		if err != nil {
+			metrics.InsertErrors.Inc()
+			status = types.LookupError
+		}
+	}
+
+	if recordErr := recordAttempt(r.User, r.Provider, status); recordErr != nil {
+		slog.Error("record attempt", "user_id", r.User.Id, "error", recordErr)
+	}
+	return
+}
+
+func (postgresSink) Close() error {
+	return nil
+}
+
+// record is a result flattened for the file sinks. The csv sink only
+// writes the summary, without the profiles.
+type record struct {
+	UserId      int             `json:"user_id,omitempty"`
+	Email       string          `json:"email"`
+	Provider    string          `json:"provider"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	FacebookUrl string          `json:"facebook_url,omitempty"`
+	TwitterUrl  string          `json:"twitter_url,omitempty"`
+	PhotoUrl    string          `json:"photo_url,omitempty"`
+	PhotoKey    string          `json:"photo_key,omitempty"`
+	Profiles    []types.Profile `json:"profiles,omitempty"`
+	At          time.Time       `json:"looked_up_at"`
+}
+
+func newRecord(r result) record {
+	rec := record{
+		UserId:      r.User.Id,
+		Email:       r.User.Email,
+		Provider:    r.Provider,
+		Status:      r.Status,
+		FacebookUrl: r.Social.FacebookUrl,
+		TwitterUrl:  r.Social.TwitterUrl,
+		PhotoUrl:    r.Social.PhotoUrl,
+		PhotoKey:    r.Social.PhotoKey,
+		Profiles:    r.Social.Profiles,
+		At:          r.At,
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+	return rec
+}
+
+// jsonlSink writes one JSON record per line. Queued lookups are left out,
+// their result is written once the provider has answered.
+type jsonlSink struct {
+	name    string
+	mu      sync.Mutex
+	w       io.Writer
+	encoder *json.Encoder
+}
+
+func newJSONLSink(name string, w io.Writer) *jsonlSink {
+	return &jsonlSink{name: name, w: w, encoder: json.NewEncoder(w)}
+}
+
+func (s *jsonlSink) Name() string {
+	return s.name
+}
+
+func (s *jsonlSink) Write(r result) error {
+	if r.Status == types.LookupQueued {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(newRecord(r))
+}
+
+func (s *jsonlSink) Close() error {
+	return closeOutput(s.w)
+}
+
+var csvHeader = []string{"user_id", "email", "provider", "status", "error", "facebook_url", "twitter_url", "photo_url", "photo_key", "looked_up_at"}
+
+// csvSink writes one row per result, under a header when the file is new.
+// Like jsonlSink it leaves queued lookups out.
+type csvSink struct {
+	name   string
+	mu     sync.Mutex
+	w      io.Writer
+	writer *csv.Writer
+}
+
+func newCSVSink(name string, w io.Writer) (*csvSink, error) {
+
+	s := &csvSink{name: name, w: w, writer: csv.NewWriter(w)}
+
+	if file, ok := w.(*os.File); ok && file != os.Stdout {
+		if info, err := file.Stat(); err != nil || info.Size() > 0 {
+			return s, err
+		}
+	}
+	s.writer.Write(csvHeader)
+	s.writer.Flush()
+	return s, s.writer.Error()
+}
+
+func (s *csvSink) Name() string {
+	return s.name
+}
+
+func (s *csvSink) Write(r result) error {
+
+	if r.Status == types.LookupQueued {
+		return nil
+	}
+	rec := newRecord(r)
+	id := ""
+	if rec.UserId != 0 {
+		id = strconv.Itoa(rec.UserId)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writer.Write([]string{id, rec.Email, rec.Provider, rec.Status, rec.Error,
+		rec.FacebookUrl, rec.TwitterUrl, rec.PhotoUrl, rec.PhotoKey, rec.At.Format(time.RFC3339)})
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *csvSink) Close() error {
+	return closeOutput(s.w)
+}
+
+// closeOutput closes w when it is a file the sink opened.
+func closeOutput(w io.Writer) error {
+	if file, ok := w.(*os.File); ok && file != os.Stdout {
+		return file.Close()
+	}
+	return nil
+}
+
+// multiSink writes every result to all of its sinks.
+type multiSink []Sink
+
+func (m multiSink) Name() string {
+	names := make([]string, len(m))
+	for i, sink := range m {
+		names[i] = sink.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (m multiSink) Write(r result) error {
+	var errs []string
+	for _, sink := range m {
+		if err := sink.Write(r); err != nil {
+			errs = append(errs, sink.Name()+": "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("multiSink:" + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (m multiSink) Close() error {
+	var errs []string
+	for _, sink := range m {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, sink.Name()+": "+err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New("multiSink:" + strings.Join(errs, "; "))
+	}
+	return nil
+}
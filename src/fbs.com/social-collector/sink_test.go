@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fbs.com/social-collector/types"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingSink struct{}
+
+func (failingSink) Name() string         { return "failing" }
+func (failingSink) Write(r result) error { return errors.New("disk full") }
+func (failingSink) Close() error         { return nil }
+
+func TestSink(t *testing.T) {
+
+	Convey("Sinks", t, func() {
+
+		at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		found := result{
+			User:     types.User{Id: 7, Email: "test@test.ru"},
+			Provider: "fullcontact",
+			Status:   types.LookupFound,
+			Social:   types.Social{UserId: 7, TwitterUrl: "http://twitter.com/test", Profiles: []types.Profile{{Network: "twitter", Url: "http://twitter.com/test"}}},
+			At:       at,
+		}
+		failed := result{User: types.User{Email: "other@test.ru"}, Provider: "fullcontact", Status: types.LookupError, Err: errors.New("timeout"), At: at}
+		queued := result{User: types.User{Id: 8}, Provider: "fullcontact", Status: types.LookupQueued, At: at}
+
+		dir, err := ioutil.TempDir("", "sink")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		Convey("Jsonl writes a record per line, leaving queued lookups out", func() {
+			var out bytes.Buffer
+			sink := newJSONLSink("stdout", &out)
+
+			So(sink.Write(found), ShouldBeNil)
+			So(sink.Write(queued), ShouldBeNil)
+			So(sink.Write(failed), ShouldBeNil)
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			So(lines, ShouldHaveLength, 2)
+
+			var rec record
+			So(json.Unmarshal([]byte(lines[0]), &rec), ShouldBeNil)
+			So(rec, ShouldResemble, record{UserId: 7, Email: "test@test.ru", Provider: "fullcontact", Status: "found", TwitterUrl: "http://twitter.com/test",
+				Profiles: []types.Profile{{Network: "twitter", Url: "http://twitter.com/test"}}, At: at})
+			So(lines[1], ShouldContainSubstring, `"error":"timeout"`)
+			So(lines[1], ShouldNotContainSubstring, "user_id")
+		})
+
+		Convey("Csv writes the header only to a new file", func() {
+			path := filepath.Join(dir, "results.csv")
+
+			for i := 0; i < 2; i++ {
+				sink, err := openSink("csv:" + path)
+				So(err, ShouldBeNil)
+				So(sink.Write(found), ShouldBeNil)
+				So(sink.Write(failed), ShouldBeNil)
+				So(sink.Close(), ShouldBeNil)
+			}
+
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			So(lines, ShouldHaveLength, 5)
+			So(lines[0], ShouldEqual, strings.Join(csvHeader, ","))
+			So(lines[1], ShouldEqual, "7,test@test.ru,fullcontact,found,,,http://twitter.com/test,,,2020-01-02T03:04:05Z")
+			So(lines[2], ShouldEqual, ",other@test.ru,fullcontact,error,timeout,,,,,2020-01-02T03:04:05Z")
+		})
+
+		Convey("Rejects unknown sinks and unwritable paths", func() {
+			_, err := openSink("kafka:topic")
+			So(err, ShouldNotBeNil)
+			_, err = openSink("jsonl:" + filepath.Join(dir, "missing", "results.jsonl"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Several sinks all get the result and report every failure", func() {
+			var out bytes.Buffer
+			sinks := multiSink{failingSink{}, newJSONLSink("stdout", &out), failingSink{}}
+
+			err := sinks.Write(found)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "multiSink:failing: disk full; failing: disk full")
+			So(out.String(), ShouldContainSubstring, "test@test.ru")
+			So(sinks.Name(), ShouldEqual, "failing,stdout,failing")
+		})
+
+		Convey("Configured sinks are opened together", func() {
+			defer func(saved types.Config) { cfg = saved }(cfg)
+
+			cfg.Sinks = nil
+			sink, err := newSink()
+			So(err, ShouldBeNil)
+			So(sink, ShouldResemble, postgresSink{})
+
+			cfg.Sinks = []string{"postgres", "jsonl:" + filepath.Join(dir, "results.jsonl")}
+			sink, err = newSink()
+			So(err, ShouldBeNil)
+			So(sink.Name(), ShouldEqual, "postgres,jsonl:"+filepath.Join(dir, "results.jsonl"))
+			So(sink.Close(), ShouldBeNil)
+
+			Convey("A dry run only prints", func() {
+				defer func() { dryRun = false }()
+				dryRun = true
+
+				sink, err := newSink()
+				So(err, ShouldBeNil)
+				So(sink.Name(), ShouldEqual, "stdout")
+			})
+		})
+
+		Convey("Postgres", func() {
+
+			cfg.Database.Driver = `testdb`
+			So(initDb(), ShouldBeNil)
+			defer dbMap.Db.Close()
+			defer testdb.Reset()
+
+			var queries []string
+			var recorded []driver.Value
+			testdb.SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+				queries = append(queries, query)
+				if strings.Contains(query, "social.users") {
+					return nil, errors.New("insert failed")
+				}
+				recorded = args
+				return testResult{1, 1}, nil
+			})
+
+			Convey("Records a failed save as an error", func() {
+				So(postgresSink{}.Write(found), ShouldNotBeNil)
+				So(queries, ShouldHaveLength, 4)
+				So(recorded[2], ShouldEqual, types.LookupError)
+			})
+
+			Convey("Skips users without id", func() {
+				So(postgresSink{}.Write(failed), ShouldBeNil)
+				So(queries, ShouldBeEmpty)
+			})
+
+			Convey("A dry run sweeps the source once", func() {
+				defer func() { dryRun = false }()
+				dryRun = true
+
+				selects := 0
+				testdb.SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+					selects++
+					if selects == 1 {
+						return testdb.RowsFromCSVString([]string{"id", "email"}, "7,test@test.ru"), nil
+					}
+					return testdb.RowsFromCSVString([]string{"id", "email"}, ""), nil
+				})
+
+				messages := make(chan types.User, 1)
+				workerLoop(context.Background(), testFeed(&messages))
+
+				So(selects, ShouldEqual, 2)
+				So(<-messages, ShouldResemble, types.User{Id: 7, Email: "test@test.ru"})
+				So(queries, ShouldBeEmpty)
+			})
+		})
+	})
+}
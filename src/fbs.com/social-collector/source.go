@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fbs.com/social-collector/metrics"
+	"fbs.com/social-collector/types"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// Source feeds the users to look up into the pipeline.
+type Source interface {
+	// Name identifies the source in logs.
+	Name() string
+	// Check reports whether the source can be read at all, before the
+	// pipeline starts.
+	Check() error
+	// Run sends users through f until ctx is done. A finite source returns
+	// once it has sent all of its users.
+	Run(ctx context.Context, f feed) error
+	// Find returns the user with the given id, ok being false when the
+	// source has none.
+	Find(id int) (user types.User, ok bool, err error)
+}
+
+// feed is how a source hands users to the pipeline.
+type feed struct {
+	messages *chan types.User
+	queue    *requeue
+	provider string
+}
+
+// Send hands user to the listeners and tracks it until its lookup has
+// finished. Every user handed over counts as progress of the source for
+// the health check. It returns false once ctx is done.
+func (f feed) Send(ctx context.Context, user types.User) bool {
+	f.queue.Track(user)
+	select {
+	case *f.messages <- user:
+		metrics.QueueDepth.Set(float64(len(*f.messages)))
+		pipeline.Beat()
+		return true
+	case <-ctx.Done():
+		f.queue.Done(user)
+		return false
+	}
+}
+
+// newSource returns the configured source.
+func newSource() (Source, error) {
+	s := source()
+	switch s.Type {
+	case "postgres":
+		return postgresSource{s}, nil
+	case "jsonl", "csv":
+		return fileSource{s}, nil
+	}
+	return nil, errors.New("newSource:unknown source type " + s.Type)
+}
+
+// postgresSource sweeps the source table, see worker.
+type postgresSource struct {
+	types.Source
+}
+
+func (s postgresSource) Name() string {
+	return s.Table
+}
+
+func (s postgresSource) Check() error {
+	return checkSource()
+}
+
+func (s postgresSource) Run(ctx context.Context, f feed) error {
+	workerLoop(ctx, f)
+	return nil
+}
+
+func (s postgresSource) Find(id int) (user types.User, ok bool, err error) {
+	var users []types.User
+	_, err = dbMap.Select(&users, `select u.id, u.email from `+sourceTable(s.Source)+` as u where u.id = :id`, map[string]interface{}{"id": id})
+	if err != nil || len(users) == 0 {
+		return
+	}
+	return users[0], true, nil
+}
+
+// Source defaults, matching the personal area schema.
+const (
+	defaultSourceType  = "postgres"
+	defaultSourceTable = "personal_area.user"
+	defaultSourceId    = "id"
+	defaultSourceEmail = "email"
+	defaultSourceBatch = 100
+)
+
+// source returns the configured source with defaults filled in.
+func source() types.Source {
+	s := currentCfg().Source
+	if s.Type == "" {
+		s.Type = defaultSourceType
+	}
+	if s.Table == "" {
+		s.Table = defaultSourceTable
+	}
+	if s.Id == "" {
+		s.Id = defaultSourceId
+	}
+	if s.Email == "" {
+		s.Email = defaultSourceEmail
+	}
+	if s.Batch < 1 {
+		s.Batch = defaultSourceBatch
+	}
+	return s
+}
+
+// quoteTable quotes every part of a possibly schema qualified table name.
+func quoteTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// sourceTable returns a subquery to select from in place of a table: it
+// exposes the id and email columns of the source rows having an email and
+// passing the filter. Names are quoted; the filter is trusted config,
+// checked by checkSource at startup.
+func sourceTable(s types.Source) string {
+
+	id := "u." + pq.QuoteIdentifier(s.Id)
+	email := "u." + pq.QuoteIdentifier(s.Email)
+
+	query := "(select " + id + " as id, " + email + " as email from " + quoteTable(s.Table) + " as u where " + email + " is not null"
+	if s.Filter != "" {
+		query += " and (" + s.Filter + ")"
+	}
+	return query + ")"
+}
+
+// checkSource runs the source query on no rows, so that a missing table or
+// column, or a broken filter, stops the collector at startup.
+func checkSource() error {
+	s := source()
+	_, err := dbMap.Db.Exec("select id, email from " + sourceTable(s) + " as u limit 0")
+	if err != nil {
+		return errors.New("checkSource:source " + s.Table + " is not usable: " + err.Error())
+	}
+	return nil
+}
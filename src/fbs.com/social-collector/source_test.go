@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fbs.com/social-collector/types"
+	"github.com/erikstmartin/go-testdb"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestSource(t *testing.T) {
+
+	Convey("Source", t, func() {
+
+		defer func(saved types.Config) { cfg = saved }(cfg)
+
+		Convey("Defaults to the personal area users", func() {
+			cfg.Source = types.Source{}
+			So(source(), ShouldResemble, types.Source{Type: "postgres", Table: "personal_area.user", Id: "id", Email: "email", Batch: 100})
+		})
+
+		Convey("Quotes names and applies the filter", func() {
+			s := types.Source{Table: "crm.Contacts", Id: "contact_id", Email: "mail", Filter: "u.active"}
+			So(sourceTable(s), ShouldEqual, `(select u."contact_id" as id, u."mail" as email from "crm"."Contacts" as u where u."mail" is not null and (u.active))`)
+		})
+
+		Convey("Sending a user beats for the health check", func() {
+			defer func(saved *progress) { pipeline = saved }(pipeline)
+			pipeline = newProgress()
+
+			messages := make(chan types.User, 1)
+			f := feed{messages: &messages, queue: newRequeue(&messages, 0, 0), provider: "fullcontact"}
+			So(pipeline.Live(time.Minute).Checks["worker"].Ok, ShouldBeFalse)
+
+			So(f.Send(context.Background(), types.User{Id: 1, Email: "a@test.ru"}), ShouldBeTrue)
+			So(pipeline.Live(time.Minute).Checks["worker"].Ok, ShouldBeTrue)
+		})
+
+		Convey("Checks the source against the database", func() {
+			cfg.Database.Driver = `testdb`
+			So(initDb(), ShouldBeNil)
+			defer dbMap.Db.Close()
+			defer testdb.Reset()
+
+			var checked string
+			testdb.SetExecFunc(func(query string) (driver.Result, error) {
+				checked = query
+				return testResult{0, 0}, nil
+			})
+			cfg.Source = types.Source{Table: "crm.contacts"}
+			So(checkSource(), ShouldBeNil)
+			So(checked, ShouldEqual, `select id, email from (select u."id" as id, u."email" as email from "crm"."contacts" as u where u."email" is not null) as u limit 0`)
+
+			testdb.SetExecFunc(func(query string) (driver.Result, error) {
+				return nil, errors.New(`relation "crm.contacts" does not exist`)
+			})
+			err := checkSource()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `checkSource:source crm.contacts is not usable: relation "crm.contacts" does not exist`)
+		})
+	})
+}
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Changes describes every field differing between c and next by its YAML
+// path, with the old and new values. Values of fields tagged secret are
+// not shown.
+func (c Config) Changes(next Config) (changes []string) {
+	diff(reflect.ValueOf(c), reflect.ValueOf(next), "", &changes)
+	return
+}
+
+func diff(old reflect.Value, next reflect.Value, prefix string, changes *[]string) {
+
+	t := old.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		path := prefix + yamlKey(field)
+		a, b := old.Field(i), next.Field(i)
+
+		if a.Kind() == reflect.Struct && a.Type() != durationType {
+			diff(a, b, path+".", changes)
+			continue
+		}
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			continue
+		}
+
+		if field.Tag.Get("secret") == "true" {
+			*changes = append(*changes, path+" changed")
+		} else {
+			*changes = append(*changes, fmt.Sprintf("%s: %v -> %v", path, a.Interface(), b.Interface()))
+		}
+	}
+}
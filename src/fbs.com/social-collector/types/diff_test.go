@@ -0,0 +1,41 @@
+package types
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestChanges(t *testing.T) {
+
+	Convey("Changes", t, func() {
+
+		old := validConfig()
+
+		Convey("Is empty for equal configs", func() {
+			So(old.Changes(old), ShouldBeEmpty)
+		})
+
+		Convey("Lists changed fields by yaml path", func() {
+			next := old
+			next.Concurrency = 4
+			next.Fullcontact.Retry.Delay = 2 * time.Second
+
+			So(old.Changes(next), ShouldResemble, []string{
+				"concurrency: 0 -> 4",
+				"fullcontact.retry.delay: 0s -> 2s",
+			})
+		})
+
+		Convey("Hides the values of secrets", func() {
+			next := old
+			next.Fullcontact.ApiKey = "rotated"
+			next.Database.Password = "rotated"
+
+			So(old.Changes(next), ShouldResemble, []string{
+				"fullcontact.key changed",
+				"database.password changed",
+			})
+		})
+	})
+}
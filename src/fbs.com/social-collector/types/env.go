@@ -0,0 +1,139 @@
+package types
+
+import (
+	"errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix prefixes the environment variables overriding the config.
+const EnvPrefix = "SOCIAL_COLLECTOR_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ApplyEnv overrides config fields from environment variables named after
+// their YAML path, e.g. SOCIAL_COLLECTOR_DATABASE_PASSWORD for
+// database.password. A NAME_FILE variable reads the value from a file
+// instead, which suits mounted secrets. Lists are comma separated, maps
+// such as emails.rules are given in YAML flow style and replace the whole
+// map.
+//
+// A variable overrides the YAML file. NAME and NAME_FILE are mutually
+// exclusive: setting both is an error rather than one silently winning.
+func (c *Config) ApplyEnv(prefix string, lookup func(string) (string, bool)) error {
+	var errs []string
+	applyEnv(reflect.ValueOf(c).Elem(), prefix, lookup, &errs)
+	if len(errs) > 0 {
+		return errors.New("ApplyEnv:" + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool), errs *[]string) {
+
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := prefix + strings.ToUpper(yamlKey(field))
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct {
+			applyEnv(value, name+"_", lookup, errs)
+			continue
+		}
+
+		raw, ok := lookup(name)
+		path, fromFile := lookup(name + "_FILE")
+		if ok && fromFile {
+			*errs = append(*errs, "both "+name+" and "+name+"_FILE are set")
+			continue
+		}
+		if fromFile {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				*errs = append(*errs, name+"_FILE: "+err.Error())
+				continue
+			}
+			raw, ok = strings.TrimRight(string(data), "\r\n"), true
+		}
+		if !ok {
+			continue
+		}
+
+		if err := setValue(value, raw); err != nil {
+			*errs = append(*errs, name+": "+err.Error())
+		}
+	}
+}
+
+// yamlKey returns the key yaml.v2 maps to field.
+func yamlKey(field reflect.StructField) string {
+	if tag := strings.Split(field.Tag.Get("yaml"), ",")[0]; tag != "" {
+		return tag
+	}
+	return strings.ToLower(field.Name)
+}
+
+func setValue(v reflect.Value, raw string) error {
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Map:
+		m := reflect.New(v.Type())
+		if err := yaml.Unmarshal([]byte(raw), m.Interface()); err != nil {
+			return err
+		}
+		v.Set(m.Elem())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return errors.New("unsupported type " + v.Type().String())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return errors.New("unsupported type " + v.Type().String())
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package types
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+
+	Convey("ApplyEnv", t, func() {
+
+		env := map[string]string{}
+		lookup := func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		}
+
+		c := Config{Provider: "fullcontact", Concurrency: 1}
+		c.Fullcontact.ApiKey = "from-yaml"
+
+		Convey("Keeps the file values without variables", func() {
+			So(c.ApplyEnv(EnvPrefix, lookup), ShouldBeNil)
+			So(c.Fullcontact.ApiKey, ShouldEqual, "from-yaml")
+			So(c.Concurrency, ShouldEqual, 1)
+		})
+
+		Convey("Overrides fields by their yaml path", func() {
+			env["SOCIAL_COLLECTOR_FULLCONTACT_KEY"] = "from-env"
+			env["SOCIAL_COLLECTOR_CONCURRENCY"] = "4"
+			env["SOCIAL_COLLECTOR_SHUTDOWN_GRACE"] = "10s"
+			env["SOCIAL_COLLECTOR_FULLCONTACT_RETRY_JITTER"] = "0.5"
+
+			So(c.ApplyEnv(EnvPrefix, lookup), ShouldBeNil)
+			So(c.Fullcontact.ApiKey, ShouldEqual, "from-env")
+			So(c.Concurrency, ShouldEqual, 4)
+			So(c.ShutdownGrace, ShouldEqual, 10*time.Second)
+			So(c.Fullcontact.Retry.Jitter, ShouldEqual, 0.5)
+		})
+
+		Convey("Reads _FILE variables from the file, without the trailing newline", func() {
+			dir, err := ioutil.TempDir("", "env")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			secret := filepath.Join(dir, "key")
+			So(ioutil.WriteFile(secret, []byte("from-file\n"), 0600), ShouldBeNil)
+
+			env["SOCIAL_COLLECTOR_FULLCONTACT_KEY_FILE"] = secret
+
+			So(c.ApplyEnv(EnvPrefix, lookup), ShouldBeNil)
+			So(c.Fullcontact.ApiKey, ShouldEqual, "from-file")
+		})
+
+		Convey("Rejects a variable set both directly and from a file", func() {
+			env["SOCIAL_COLLECTOR_FULLCONTACT_KEY"] = "from-env"
+			env["SOCIAL_COLLECTOR_FULLCONTACT_KEY_FILE"] = "/nonexistent"
+
+			err := c.ApplyEnv(EnvPrefix, lookup)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "both SOCIAL_COLLECTOR_FULLCONTACT_KEY and SOCIAL_COLLECTOR_FULLCONTACT_KEY_FILE are set")
+		})
+
+		Convey("Reads maps in YAML flow style", func() {
+			env["SOCIAL_COLLECTOR_EMAILS_RULES"] = `{example.com: {dots: true, plus: true}, example.org: {alias: example.com}}`
+
+			So(c.ApplyEnv(EnvPrefix, lookup), ShouldBeNil)
+			So(c.Emails.Rules, ShouldResemble, map[string]EmailRule{
+				"example.com": {Dots: true, Plus: true},
+				"example.org": {Alias: "example.com"},
+			})
+
+			env["SOCIAL_COLLECTOR_EMAILS_RULES"] = `{example.com: [`
+			So(c.ApplyEnv(EnvPrefix, lookup), ShouldNotBeNil)
+		})
+
+		Convey("Reports every malformed value at once", func() {
+			env["SOCIAL_COLLECTOR_CONCURRENCY"] = "many"
+			env["SOCIAL_COLLECTOR_TTL"] = "forever"
+			env["SOCIAL_COLLECTOR_DATABASE_PASSWORD_FILE"] = "/nonexistent"
+
+			err := c.ApplyEnv(EnvPrefix, lookup)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "SOCIAL_COLLECTOR_CONCURRENCY")
+			So(err.Error(), ShouldContainSubstring, "SOCIAL_COLLECTOR_TTL")
+			So(err.Error(), ShouldContainSubstring, "SOCIAL_COLLECTOR_DATABASE_PASSWORD_FILE")
+		})
+	})
+}
@@ -2,41 +2,176 @@ package types
 
 import (
 	"errors"
+	"time"
 )
 
 type Config struct {
-	Fullcontact struct {
-		Url    string
-		ApiKey string `yaml:"key"`
+	Provider      string
+	Concurrency   int
+	Cooldown      time.Duration
+	TTL           time.Duration
+	ShutdownGrace time.Duration `yaml:"shutdown_grace"`
+	Fullcontact   struct {
+		Url       string
+		ApiKey    string `yaml:"key" secret:"true"`
+		RateLimit int
+		Retry     Retry
 	}
+	Log struct {
+		Format string
+		Level  string
+	}
+	Http struct {
+		Listen         string
+		HealthDeadline time.Duration `yaml:"health_deadline"`
+	}
+	// Api serves the enrichment API on a listener of its own, apart from
+	// metrics. Requests must carry Token as a bearer token when it is set.
+	Api struct {
+		Listen string
+		Token  string `secret:"true"`
+	}
+	Reload struct {
+		Watch time.Duration
+	}
+	Source Source
+	// Sinks receive the lookup results: postgres, stdout, or jsonl:path
+	// and csv:path, "-" being stdout.
+	Sinks  []string
+	Notify struct {
+		Enabled bool
+		Channel string
+		Sweep   time.Duration
+	}
+	Requeue struct {
+		Attempts int
+		Delay    time.Duration
+	}
+	Photos   Photos
+	Emails   Emails
 	Database struct {
 		Driver   string
 		Database string
 		Username string
-		Password string
+		Password string `secret:"true"`
 		Port     int
 		Host     string
+		Connect  struct {
+			Attempts int
+			Delay    time.Duration
+		}
+	}
+}
+
+// Source is where users are read from: a postgres table, or a jsonl or csv
+// file at Path, "-" being stdin. Id and Email name the columns, or keys, of
+// the user fields. Filter is an extra SQL predicate on the table rows, which
+// it refers to with the alias u.
+type Source struct {
+	Type   string
+	Path   string
+	Table  string
+	Id     string
+	Email  string
+	Filter string
+	Batch  int
+}
+
+// Photos mirrors the photo of found users, up to MaxSize bytes, into a blob
+// store: a directory at Path for the local type.
+type Photos struct {
+	Enabled bool
+	MaxSize int `yaml:"max_size"`
+	Timeout time.Duration
+	Store   struct {
+		Type string
+		Path string
+	}
+}
+
+// Emails configures how addresses are canonicalized before a lookup, so
+// that users sharing a mailbox are looked up once. Lookup results are
+// cached by canonical address for Cache.TTL, at most Cache.Size of them.
+// Rules are keyed by domain, "*" applying to domains without a rule of
+// their own.
+type Emails struct {
+	Cache struct {
+		TTL  time.Duration
+		Size int
 	}
+	Rules map[string]EmailRule
+}
+
+// EmailRule normalizes the addresses of a domain: Dots drops the dots of
+// the local part, Plus drops its +tag suffix and Alias replaces the domain.
+type EmailRule struct {
+	Dots  bool
+	Plus  bool
+	Alias string
+}
+
+// Retry is the policy for resending a failed provider request.
+type Retry struct {
+	Attempts int
+	Delay    time.Duration
+	Jitter   float64
+	Deadline time.Duration
 }
 
+// Social summarizes what was found about a user in social.users: the
+// Twitter and Facebook profiles and the primary photo, with the blob store
+// key of its mirrored copy when photos are mirrored. Profiles holds every
+// profile found, on any network, and is stored in social.profiles.
 type Social struct {
-	UserId      int    `db:"user_id"`
-	FacebookUrl string `db:"facebook_url"`
-	TwitterUrl  string `db:"twitter_url"`
-	PhotoUrl    string `db:"photo_url"`
+	UserId      int       `db:"user_id" json:"user_id"`
+	FacebookUrl string    `db:"facebook_url" json:"facebook_url,omitempty"`
+	TwitterUrl  string    `db:"twitter_url" json:"twitter_url,omitempty"`
+	PhotoUrl    string    `db:"photo_url" json:"photo_url,omitempty"`
+	PhotoKey    string    `db:"photo_key" json:"photo_key,omitempty"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+	Profiles    []Profile `db:"-" json:"profiles,omitempty"`
+}
+
+// Profile is an account of the user on a social network, as stored in
+// social.profiles.
+type Profile struct {
+	UserId    int    `db:"user_id" json:"-"`
+	Network   string `db:"network" json:"network"`
+	Username  string `db:"username" json:"username,omitempty"`
+	ProfileId string `db:"profile_id" json:"profile_id,omitempty"`
+	Url       string `db:"url" json:"url"`
+	Bio       string `db:"bio" json:"bio,omitempty"`
+	Followers int    `db:"followers" json:"followers,omitempty"`
+	Following int    `db:"following" json:"following,omitempty"`
 }
 
 func (s Social) IsValid() error {
 	if s.UserId == 0 {
 		return errors.New("UserId not found.")
 	}
-	if s.TwitterUrl != "" || s.FacebookUrl != "" || s.PhotoUrl != "" {
+	if s.HasProfiles() {
 		return nil
 	} else {
-		return errors.New("TwitterUrl or FacebookUrl or PhotoUrl not found.")
+		return errors.New("TwitterUrl or FacebookUrl or PhotoUrl or Profiles not found.")
 	}
 }
 
+// HasProfiles reports whether any profile or photo was found, regardless of
+// the user it belongs to.
+func (s Social) HasProfiles() bool {
+	return s.TwitterUrl != "" || s.FacebookUrl != "" || s.PhotoUrl != "" || len(s.Profiles) > 0
+}
+
+// Lookup statuses recorded in social.lookup_attempts. Errors are retried by
+// the next sweep, any other status waits for the cool-down.
+const (
+	LookupFound    = "found"
+	LookupNotFound = "not_found"
+	LookupQueued   = "queued"
+	LookupRejected = "rejected"
+	LookupError    = "error"
+)
+
 type User struct {
 	Id    int
 	Email string
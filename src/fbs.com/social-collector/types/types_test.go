@@ -1,6 +1,7 @@
 package types
 
 import (
+	"encoding/json"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
 )
@@ -35,5 +36,21 @@ func TestTypes(t *testing.T) {
 			So(s.IsValid(), ShouldBeNil)
 		})
 
+		Convey("Social empty type with UserId > 0 and only other networks is valid", func() {
+			s := Social{UserId: 1, Profiles: []Profile{{Network: "github", Url: "https://github.com/test"}}}
+			So(s.IsValid(), ShouldBeNil)
+		})
+
+		Convey("Social without UserId has profiles when any url is set", func() {
+			So(Social{}.HasProfiles(), ShouldBeFalse)
+			So(Social{PhotoUrl: "https://test.com"}.HasProfiles(), ShouldBeTrue)
+		})
+
+		Convey("Social is encoded in snake_case like its profiles", func() {
+			data, err := json.Marshal(Social{UserId: 1, TwitterUrl: "http://twitter.com/test", Profiles: []Profile{{UserId: 1, Network: "twitter", Url: "http://twitter.com/test"}}})
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `{"user_id":1,"twitter_url":"http://twitter.com/test","updated_at":"0001-01-01T00:00:00Z","profiles":[{"network":"twitter","url":"http://twitter.com/test"}]}`)
+		})
+
 	})
 }
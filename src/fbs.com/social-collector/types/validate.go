@@ -0,0 +1,204 @@
+package types
+
+import (
+	"log/slog"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unquoted SQL names, tables optionally schema qualified.
+var (
+	columnPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	tablePattern  = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*\.)?[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ConfigError lists every problem found in a config, one per entry.
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return "invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+// Problems checks the whole config and describes each invalid field by its
+// YAML path. An empty result means the config is usable.
+func (c Config) Problems() (problems []string) {
+
+	required := func(path string, value string) {
+		if strings.TrimSpace(value) == "" {
+			problems = append(problems, path+" is empty")
+		}
+	}
+	atLeast := func(path string, value int, min int) {
+		if value < min {
+			problems = append(problems, path+" must not be less than "+strconv.Itoa(min)+", got "+strconv.Itoa(value))
+		}
+	}
+	notNegative := func(path string, value time.Duration) {
+		if value < 0 {
+			problems = append(problems, path+" must not be negative, got "+value.String())
+		}
+	}
+
+	atLeast("database.connect.attempts", c.Database.Connect.Attempts, 0)
+	notNegative("database.connect.delay", c.Database.Connect.Delay)
+
+	atLeast("concurrency", c.Concurrency, 0)
+	notNegative("cooldown", c.Cooldown)
+	notNegative("ttl", c.TTL)
+	notNegative("shutdown_grace", c.ShutdownGrace)
+
+	switch c.Log.Format {
+	case "", "json", "logfmt", "text":
+	default:
+		problems = append(problems, "log.format must be json or logfmt, got "+c.Log.Format)
+	}
+	if c.Log.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+			problems = append(problems, "log.level must be debug, info, warn or error, got "+c.Log.Level)
+		}
+	}
+
+	if c.Http.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Http.Listen); err != nil {
+			problems = append(problems, "http.listen must be host:port, got "+c.Http.Listen)
+		}
+	}
+	notNegative("http.health_deadline", c.Http.HealthDeadline)
+
+	if c.Api.Listen != "" {
+		if host, _, err := net.SplitHostPort(c.Api.Listen); err != nil {
+			problems = append(problems, "api.listen must be host:port, got "+c.Api.Listen)
+		} else if c.Api.Token == "" && !isLoopback(host) {
+			problems = append(problems, "api.token is empty, which only a loopback api.listen allows, got "+c.Api.Listen)
+		}
+	}
+
+	notNegative("reload.watch", c.Reload.Watch)
+
+	switch c.Source.Type {
+	case "", "postgres":
+	case "jsonl", "csv":
+		required("source.path", c.Source.Path)
+	default:
+		problems = append(problems, "source.type must be postgres, jsonl or csv, got "+c.Source.Type)
+	}
+	if c.Source.Table != "" && !tablePattern.MatchString(c.Source.Table) {
+		problems = append(problems, "source.table must be a table name, optionally schema qualified, got "+c.Source.Table)
+	}
+	for _, column := range []struct{ path, value string }{
+		{"source.id", c.Source.Id},
+		{"source.email", c.Source.Email},
+	} {
+		if column.value != "" && !columnPattern.MatchString(column.value) {
+			problems = append(problems, column.path+" must be a column name, got "+column.value)
+		}
+	}
+	if strings.ContainsAny(c.Source.Filter, ";") || strings.Contains(c.Source.Filter, "--") || strings.Contains(c.Source.Filter, "/*") {
+		problems = append(problems, "source.filter must be a single predicate, without ; or comments")
+	}
+	atLeast("source.batch", c.Source.Batch, 0)
+
+	for _, sink := range c.Sinks {
+		kind, path, _ := strings.Cut(sink, ":")
+		switch kind {
+		case "postgres", "stdout":
+			if path != "" {
+				problems = append(problems, "sinks: "+kind+" takes no path, got "+sink)
+			}
+		case "jsonl", "csv":
+			if strings.TrimSpace(path) == "" {
+				problems = append(problems, "sinks: "+kind+" needs a path, e.g. "+kind+":results."+kind)
+			}
+		default:
+			problems = append(problems, "sinks must be postgres, stdout, jsonl:path or csv:path, got "+sink)
+		}
+	}
+
+	if c.Notify.Channel != "" && !columnPattern.MatchString(c.Notify.Channel) {
+		problems = append(problems, "notify.channel must be a plain SQL name, got "+c.Notify.Channel)
+	}
+	notNegative("notify.sweep", c.Notify.Sweep)
+
+	atLeast("requeue.attempts", c.Requeue.Attempts, 0)
+	notNegative("requeue.delay", c.Requeue.Delay)
+
+	if c.Photos.Enabled {
+		switch c.Photos.Store.Type {
+		case "", "local":
+			required("photos.store.path", c.Photos.Store.Path)
+		default:
+			problems = append(problems, "photos.store.type must be local, got "+c.Photos.Store.Type)
+		}
+	}
+	atLeast("photos.max_size", c.Photos.MaxSize, 0)
+	notNegative("photos.timeout", c.Photos.Timeout)
+
+	notNegative("emails.cache.ttl", c.Emails.Cache.TTL)
+	atLeast("emails.cache.size", c.Emails.Cache.Size, 0)
+	for domain, rule := range c.Emails.Rules {
+		if domain == "" || strings.ContainsAny(domain, "@ ") {
+			problems = append(problems, "emails.rules must be keyed by domain, got "+strconv.Quote(domain))
+		}
+		if strings.ContainsAny(rule.Alias, "@ ") {
+			problems = append(problems, "emails.rules."+domain+".alias must be a domain, got "+rule.Alias)
+		}
+	}
+
+	if c.Provider == "" || c.Provider == "fullcontact" {
+		required("fullcontact.key", c.Fullcontact.ApiKey)
+		if u, err := url.Parse(c.Fullcontact.Url); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, "fullcontact.url must be an absolute URL, got "+c.Fullcontact.Url)
+		}
+	}
+	atLeast("fullcontact.ratelimit", c.Fullcontact.RateLimit, 0)
+	atLeast("fullcontact.retry.attempts", c.Fullcontact.Retry.Attempts, 0)
+	notNegative("fullcontact.retry.delay", c.Fullcontact.Retry.Delay)
+	notNegative("fullcontact.retry.deadline", c.Fullcontact.Retry.Deadline)
+	if c.Fullcontact.Retry.Jitter < 0 || c.Fullcontact.Retry.Jitter > 1 {
+		problems = append(problems, "fullcontact.retry.jitter must be between 0 and 1")
+	}
+
+	return
+}
+
+// DatabaseProblems checks the connection settings of the database, which
+// only a pipeline reading or writing it needs. Problems leaves them out.
+func (c Config) DatabaseProblems() (problems []string) {
+	for _, field := range []struct{ path, value string }{
+		{"database.driver", c.Database.Driver},
+		{"database.host", c.Database.Host},
+		{"database.database", c.Database.Database},
+		{"database.username", c.Database.Username},
+	} {
+		if strings.TrimSpace(field.value) == "" {
+			problems = append(problems, field.path+" is empty")
+		}
+	}
+	if c.Database.Port < 1 || c.Database.Port > 65535 {
+		problems = append(problems, "database.port must be between 1 and 65535, got "+strconv.Itoa(c.Database.Port))
+	}
+	return
+}
+
+// isLoopback reports whether host only accepts local connections.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Validate returns a ConfigError listing every problem of the config, nil
+// when there is none.
+func (c Config) Validate() error {
+	if problems := c.Problems(); len(problems) > 0 {
+		return ConfigError(problems)
+	}
+	return nil
+}
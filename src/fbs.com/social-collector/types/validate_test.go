@@ -0,0 +1,175 @@
+package types
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func validConfig() (c Config) {
+	c.Provider = "fullcontact"
+	c.Database.Driver = "postgres"
+	c.Database.Host = "localhost"
+	c.Database.Database = "social"
+	c.Database.Username = "collector"
+	c.Database.Port = 5432
+	c.Fullcontact.ApiKey = "key"
+	c.Fullcontact.Url = "https://api.fullcontact.com/v2/person.json"
+	c.Http.Listen = ":9102"
+	c.Log.Level = "info"
+	return
+}
+
+func TestValidate(t *testing.T) {
+
+	Convey("Validate", t, func() {
+
+		Convey("Accepts a complete config", func() {
+			So(validConfig().Validate(), ShouldBeNil)
+		})
+
+		Convey("Reports every problem by its yaml path", func() {
+			c := validConfig()
+			c.Database.Host = " "
+			c.Database.Port = 0
+			c.Fullcontact.ApiKey = ""
+			c.Fullcontact.Url = "api.fullcontact.com"
+			c.TTL = -time.Hour
+			c.Log.Format = "xml"
+			c.Log.Level = "loud"
+			c.Http.Listen = "9102"
+			c.Fullcontact.Retry.Jitter = 2
+
+			err := c.Validate()
+			So(err, ShouldNotBeNil)
+			So(err, ShouldHaveSameTypeAs, ConfigError{})
+			So(err.(ConfigError), ShouldHaveLength, 7)
+			So(err.Error(), ShouldStartWith, "invalid config:\n  ttl must not be negative")
+			So(err.Error(), ShouldContainSubstring, "fullcontact.key is empty")
+			So(err.Error(), ShouldContainSubstring, "ttl must not be negative, got -1h0m0s")
+		})
+
+		Convey("Checks the database settings apart", func() {
+			c := validConfig()
+			So(c.DatabaseProblems(), ShouldBeEmpty)
+
+			c.Database.Host = " "
+			c.Database.Port = 0
+			So(c.Validate(), ShouldBeNil)
+			So(c.DatabaseProblems(), ShouldResemble, []string{"database.host is empty", "database.port must be between 1 and 65535, got 0"})
+		})
+
+		Convey("Rejects source names and filters that are not plain SQL", func() {
+			c := validConfig()
+			c.Source = Source{Table: "users; drop table users", Id: "u.id", Email: "email", Filter: "true --", Batch: -1}
+
+			err := c.Validate()
+			So(err, ShouldNotBeNil)
+			So(err.(ConfigError), ShouldHaveLength, 4)
+			So(err.Error(), ShouldContainSubstring, "source.table must be a table name")
+			So(err.Error(), ShouldContainSubstring, "source.id must be a column name, got u.id")
+
+			c.Source = Source{Table: "crm.contacts", Id: "contact_id", Email: "mail", Filter: "u.active and u.email like '%@%'", Batch: 50}
+			So(c.Validate(), ShouldBeNil)
+		})
+
+		Convey("Requires a path for file sources", func() {
+			c := validConfig()
+			c.Source.Type = "xml"
+			err := c.Validate()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "source.type must be postgres, jsonl or csv, got xml")
+
+			c.Source.Type = "csv"
+			err = c.Validate()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "source.path is empty")
+
+			c.Source.Path = "-"
+			So(c.Validate(), ShouldBeNil)
+		})
+
+		Convey("Checks every sink", func() {
+			c := validConfig()
+			c.Sinks = []string{"postgres", "csv:", "stdout:x", "kafka"}
+			err := c.Validate()
+			So(err, ShouldNotBeNil)
+			So(err.(ConfigError), ShouldHaveLength, 3)
+			So(err.Error(), ShouldContainSubstring, "sinks: csv needs a path")
+			So(err.Error(), ShouldContainSubstring, "sinks must be postgres, stdout, jsonl:path or csv:path, got kafka")
+
+			c.Sinks = []string{"postgres", "stdout", "jsonl:/tmp/results.jsonl", "csv:-"}
+			So(c.Validate(), ShouldBeNil)
+		})
+
+		Convey("Needs a store when photos are mirrored", func() {
+			c := validConfig()
+			c.Photos.Enabled = true
+			err := c.Validate()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "photos.store.path is empty")
+
+			c.Photos.Store.Type = "s3"
+			err = c.Validate()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "photos.store.type must be local, got s3")
+
+			c.Photos.Store.Type = "local"
+			c.Photos.Store.Path = "/var/lib/photos"
+			So(c.Validate(), ShouldBeNil)
+		})
+
+		Convey("Checks the email rules", func() {
+			c := validConfig()
+			c.Emails.Cache.Size = -1
+			c.Emails.Rules = map[string]EmailRule{
+				"gmail.com":   {Dots: true, Plus: true},
+				"*":           {Plus: true},
+				"me@test.com": {},
+				"example.org": {Alias: "x@example.com"},
+			}
+			err := c.Validate()
+			So(err, ShouldNotBeNil)
+			So(err.(ConfigError), ShouldHaveLength, 3)
+			So(err.Error(), ShouldContainSubstring, `emails.rules must be keyed by domain, got "me@test.com"`)
+			So(err.Error(), ShouldContainSubstring, "emails.rules.example.org.alias must be a domain")
+		})
+
+		Convey("Requires a token unless the API only listens on loopback", func() {
+			c := validConfig()
+			c.Api.Listen = "127.0.0.1:9103"
+			So(c.Validate(), ShouldBeNil)
+
+			c.Api.Listen = ":9103"
+			err := c.Validate()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "api.token is empty")
+
+			c.Api.Token = "secret"
+			So(c.Validate(), ShouldBeNil)
+
+			c.Api.Listen = "9103"
+			So(c.Validate(), ShouldNotBeNil)
+		})
+
+		Convey("Rejects a notify channel that is not a plain name", func() {
+			c := validConfig()
+			c.Notify.Channel = "new users"
+			So(c.Validate(), ShouldNotBeNil)
+
+			c.Notify.Channel = "new_users"
+			So(c.Validate(), ShouldBeNil)
+		})
+
+		Convey("Only requires fullcontact settings when it is the provider", func() {
+			c := validConfig()
+			c.Provider = "other"
+			c.Fullcontact.ApiKey = ""
+			c.Fullcontact.Url = ""
+			So(c.Validate(), ShouldBeNil)
+
+			c.Provider = ""
+			So(c.Validate(), ShouldNotBeNil)
+		})
+	})
+}